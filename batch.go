@@ -0,0 +1,108 @@
+package skini
+
+/*
+Batch -- parses many files at once with bounded
+number of workers. Each file is read once, into its
+own target.
+*/
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// Outcome of parsing single file
+type FileResult struct {
+	// Target filled by file, as returned by newTarget
+	Target interface{}
+
+	// Origins of values, if options ask for Meta
+	Meta *Meta
+
+	Err error
+}
+
+// Parses every file of directory matching pattern, like
+// *.ini, into new target. Results are keyed by path of file.
+// Workers call newTarget, it must be safe to call at once.
+func ParseAll(dir, pattern string, newTarget func() interface{}, opts *Options) (map[string]*FileResult, error) {
+	return ParseAllContext(context.Background(), dir, pattern, newTarget, opts)
+}
+
+// Parses files of directory within context. Files left when
+// context is done fail with its error. Meta of options is
+// left untouched, each file gets its own in its result.
+func ParseAllContext(ctx context.Context, dir, pattern string, newTarget func() interface{}, opts *Options) (map[string]*FileResult, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning directory: %s, error = %s", dir, err)
+	}
+	if err = checkPattern(pattern); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, e := range entries {
+		if ok, _ := path.Match(pattern, e.Name()); ok && !e.IsDir() {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if opts != nil && opts.Workers > 0 {
+		workers = opts.Workers
+	}
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	// Workers take files by index and fill results at it
+	results := make([]*FileResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = parseOne(ctx, files[i], newTarget, opts)
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	out := make(map[string]*FileResult, len(files))
+	for i, file := range files {
+		out[file] = results[i]
+	}
+	return out, nil
+}
+
+// Parses single file of batch.
+func parseOne(ctx context.Context, filename string, newTarget func() interface{}, opts *Options) *FileResult {
+	res := &FileResult{Target: newTarget()}
+	if res.Err = ctx.Err(); res.Err != nil {
+		return res
+	}
+
+	var own *Options
+	if opts != nil {
+		copied := *opts
+		if opts.Meta != nil {
+			res.Meta = &Meta{}
+			copied.Meta = res.Meta
+		}
+		own = &copied
+	}
+	res.Err = ParseFileContext(ctx, res.Target, filename, own)
+	return res
+}
@@ -0,0 +1,282 @@
+package skini
+
+/*
+Builder -- fluent construction of documents:
+
+  doc := NewDocument()
+  doc.Root().Comment("Application").Set("id", "/home")
+  doc.Section("server.http").List("colors", "red", "green")
+  doc.Map("Press", "ABC").Text("blurb", longText)
+
+Names, keys and values that would read back differently
+are rejected. First such error stops building and is
+returned by Err, so document written by a builder without
+error reads back into the same blocks and entries.
+
+Comment lines join text written with += and start a list
+after empty value. Entry followed by comment is therefore
+kept as it reads back: text as plain value, empty value as
+empty list.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Creates empty document with root block.
+func NewDocument() *Document {
+	return &Document{Blocks: []*Block{{Kind: BlockRoot}}}
+}
+
+// Returns first error of building.
+func (doc *Document) Err() error {
+	return doc.err
+}
+
+// Returns root block.
+func (doc *Document) Root() *Block {
+	if len(doc.Blocks) == 0 || doc.Blocks[0].Kind != BlockRoot {
+		doc.Blocks = append([]*Block{{Kind: BlockRoot}}, doc.Blocks...)
+	}
+	return doc.builder(doc.Blocks[0])
+}
+
+// Returns [name] section, adding it if not present.
+func (doc *Document) Section(name string) *Block {
+	like := &Block{Kind: BlockSection, Name: name}
+	if _, ok := splitSection(name); !ok || name != strings.TrimSpace(name) {
+		doc.fail(fmt.Errorf("error, invalid section name: %q", name))
+		return doc.builder(like)
+	}
+	return doc.block(like)
+}
+
+// Returns [map.name | key] block, [map.name] if key
+// is empty, adding it if not present.
+func (doc *Document) Map(name, key string) *Block {
+	like := &Block{Kind: BlockMap, Name: name, Key: key}
+	header := like.Header()
+	if n, k, ok := splitMap(header[1 : len(header)-1]); !ok || n != name || k != key {
+		doc.fail(fmt.Errorf("error, invalid map: %q | %q", name, key))
+		return doc.builder(like)
+	}
+	return doc.block(like)
+}
+
+// Gets block with header of given one, adds it if not present.
+func (doc *Document) block(like *Block) *Block {
+	doc.Root()
+	header := like.Header()
+	for _, b := range doc.Blocks {
+		if b.Header() == header {
+			return doc.builder(b)
+		}
+	}
+	doc.Blocks = append(doc.Blocks, like)
+	return doc.builder(like)
+}
+
+// Links block to document for building.
+func (doc *Document) builder(b *Block) *Block {
+	b.doc = doc
+	return b
+}
+
+// Remembers first error.
+func (doc *Document) fail(err error) {
+	if doc.err == nil {
+		doc.err = err
+	}
+}
+
+//------------------------------------------------------------
+// Block building
+//------------------------------------------------------------
+
+// Adds comment above header of block.
+func (b *Block) HeaderComment(text string) *Block {
+	if b.Kind == BlockRoot {
+		b.fail(fmt.Errorf("error, root block has no header"))
+		return b
+	}
+	b.Comments = append(b.Comments, commentLines(text)...)
+	if b.doc != nil && commented(b.Comments) {
+		settle(b.doc.entryBefore(b))
+	}
+	return b
+}
+
+// Adds comment above next entry of block.
+func (b *Block) Comment(text string) *Block {
+	b.pending = append(b.pending, commentLines(text)...)
+	return b
+}
+
+// Sets key to value, replacing earlier value of key.
+func (b *Block) Set(key, value string) *Block {
+	ok := value == strings.TrimSpace(value) && !strings.ContainsAny(value, "\r\n")
+	if b.checkKey(key) && b.check(ok, "value", value) {
+		b.entry(key, &Entry{Kind: EntryValue, Value: value})
+	}
+	return b
+}
+
+// Sets key to list of items. Key without items is set
+// to empty value, as that is how it reads back.
+func (b *Block) List(key string, items ...string) *Block {
+	if !b.checkKey(key) {
+		return b
+	}
+	for _, item := range items {
+		tok := &token{}
+		if item != "" && item == strings.TrimSpace(item) {
+			classify(tok, item)
+		}
+		if !b.check(tok.typ == tokValue && !tok.likeHeader, "list item", item) {
+			return b
+		}
+	}
+	if len(items) == 0 {
+		return b.Set(key, "")
+	}
+	b.entry(key, &Entry{Kind: EntryList, Items: append([]string{}, items...)})
+	return b
+}
+
+// Sets key to text written with +=. Runs of whitespace,
+// line breaks too, are read back as single space, so
+// they are stored as such.
+func (b *Block) Text(key, text string) *Block {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return b.Set(key, "")
+	}
+	if b.checkKey(key) {
+		b.entry(key, &Entry{Kind: EntryValue, Value: text, Append: true})
+	}
+	return b
+}
+
+// Adds @include of path.
+func (b *Block) Include(path string) *Block {
+	if b.check(path != "" && !strings.ContainsAny(path, "\r\n"), "include path", path) {
+		b.entry("", &Entry{Kind: EntryInclude, Value: path})
+	}
+	return b
+}
+
+// Sets entry of key, or adds it, with pending comments.
+func (b *Block) entry(key string, e *Entry) {
+	e.Key = key
+	at := -1
+	for i := len(b.Entries) - 1; i >= 0 && key != ""; i-- {
+		if o := b.Entries[i]; o.Kind != EntryInclude && o.Key == key {
+			o.Kind, o.Value, o.Items, o.Append = e.Kind, e.Value, e.Items, e.Append
+			o.Comments = append(o.Comments, b.pending...)
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		e.Comments = b.pending
+		b.Entries = append(b.Entries, e)
+		at = len(b.Entries) - 1
+	}
+	b.pending = nil
+
+	if b.doc == nil {
+		return
+	}
+	if at > 0 && commented(b.Entries[at].Comments) {
+		settle(b.Entries[at-1])
+	}
+	if commented(b.doc.commentsAfter(b, at)) {
+		settle(b.Entries[at])
+	}
+}
+
+// Checks key reads back as key of key = value.
+func (b *Block) checkKey(key string) bool {
+	_, _, _, ok := splitKeyValue(key + " = v")
+	ok = ok && key == strings.TrimSpace(key) && !strings.ContainsAny(key, "=\r\n")
+	if ok {
+		tok := &token{}
+		classify(tok, key+" = v")
+		ok = tok.typ == tokKeyValue && tok.name == key
+	}
+	return b.check(ok, "key", key)
+}
+
+// Fails with invalid what unless ok.
+func (b *Block) check(ok bool, what, value string) bool {
+	if !ok {
+		b.fail(fmt.Errorf("error, invalid %s: %q", what, value))
+	}
+	return ok && (b.doc == nil || b.doc.err == nil)
+}
+
+func (b *Block) fail(err error) {
+	if b.doc != nil {
+		b.doc.fail(err)
+	}
+}
+
+// Returns entry written before header of block.
+func (doc *Document) entryBefore(b *Block) (prev *Entry) {
+	for _, o := range doc.Blocks {
+		if o == b {
+			return
+		}
+		if n := len(o.Entries); n > 0 {
+			prev = o.Entries[n-1]
+		}
+	}
+	return nil
+}
+
+// Returns comments written after entry i of block.
+func (doc *Document) commentsAfter(b *Block, i int) []string {
+	if i+1 < len(b.Entries) {
+		return b.Entries[i+1].Comments
+	}
+	for k, o := range doc.Blocks {
+		if o == b && k+1 < len(doc.Blocks) {
+			return doc.Blocks[k+1].Comments
+		}
+	}
+	return doc.Comments
+}
+
+// Do comments join text or start list above them?
+func commented(comments []string) bool {
+	if len(comments) == 0 {
+		return false
+	}
+	tok := &token{}
+	classify(tok, comments[0])
+	return !tok.likeKeyValue
+}
+
+// Makes entry followed by comments what it reads back as.
+func settle(e *Entry) {
+	if e == nil || e.Kind != EntryValue {
+		return
+	}
+	e.Append = false
+	if e.Value == "" {
+		e.Kind = EntryList
+	}
+}
+
+// Splits text into comment lines.
+func commentLines(text string) (lines []string) {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimRight(line, " \t\r"); line == "" {
+			lines = append(lines, "#")
+		} else {
+			lines = append(lines, "# "+line)
+		}
+	}
+	return
+}
@@ -0,0 +1,114 @@
+// Command skini-lsp is a language server for improved INI files.
+// It talks JSON-RPC over stdin and stdout.
+//
+// Usage:
+//
+//	skini-lsp [-schema file.json]
+//
+// Schema is JSON Schema written by skini.JSONSchema of the struct
+// files decode into. Clients can also pass its path as "schema"
+// in initializationOptions. Without schema the server still
+// reports syntax errors, follows includes and formats.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	schema := flag.String("schema", "", "JSON Schema of files")
+	flag.Parse()
+	log.SetOutput(os.Stderr)
+
+	s := newServer(os.Stdout)
+	if *schema != "" {
+		if err := s.loadSchema(*schema); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := s.serve(os.Stdin); err != nil {
+		log.Fatal(err)
+	}
+}
+
+//------------------------------------------------------------
+// JSON-RPC
+//------------------------------------------------------------
+
+// Request, response or notification
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Reads message framed by Content-Length header.
+func readMessage(r *bufio.Reader) (msg *message, err error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("error, invalid Content-Length: %s", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("error, message without Content-Length")
+	}
+
+	data := make([]byte, length)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return
+	}
+	msg = &message{}
+	if err = json.Unmarshal(data, msg); err != nil {
+		return nil, &rpcError{codeParseError, err.Error()}
+	}
+	return
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// Writes message framed by Content-Length header.
+func writeMessage(w io.Writer, msg *message) error {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %v\r\n\r\n%s", len(data), data)
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deze333/skini"
+)
+
+// Schema of files in session
+type app struct {
+	Id         string `desc:"Application id" skini:",required"`
+	ServerHttp struct {
+		Port int    `desc:"Listen port" min:"1"`
+		Mode string `enum:"debug,release"`
+	}
+}
+
+// Test scripted JSON-RPC session
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	schema, _ := skini.JSONSchema(&app{})
+	schemaFile := filepath.Join(dir, "schema.json")
+	os.WriteFile(schemaFile, schema, 0644)
+	os.WriteFile(filepath.Join(dir, "base.ini"), []byte("[serverHttp]\n    port = 80\n"), 0644)
+
+	uri := pathToURI(filepath.Join(dir, "app.ini"))
+	text := "@include base.ini\n[serverHttp]\n    port = 0\n    mode = \n"
+	fixed := "id = a\n@include base.ini\n[serverHttp]\n  port = 8080\n"
+
+	var in bytes.Buffer
+	script := []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"initializationOptions":{"schema":%q}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":%q,"text":%q}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/completion","params":{"textDocument":{"uri":%q},"position":{"line":3,"character":11}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/hover","params":{"textDocument":{"uri":%q},"position":{"line":2,"character":6}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/definition","params":{"textDocument":{"uri":%q},"position":{"line":2,"character":6}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":%q},"contentChanges":[{"text":%q}]}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"textDocument/formatting","params":{"textDocument":{"uri":%q}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	}
+	args := [][]interface{}{{schemaFile}, {uri, text}, {uri}, {uri}, {uri}, {uri, fixed}, {uri}, nil, nil}
+	for i, line := range script {
+		msg := fmt.Sprintf(line, args[i]...)
+		fmt.Fprintf(&in, "Content-Length: %v\r\n\r\n%s", len(msg), msg)
+	}
+
+	var out bytes.Buffer
+	if err := newServer(&out).serve(&in); err != nil {
+		t.Fatalf("Error serving: %s", err)
+	}
+
+	// Collect replies by id and notifications in order
+	replies := map[string]string{}
+	var notes []string
+	r := bufio.NewReader(&out)
+	for {
+		msg, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		data, _ := json.Marshal(msg.Result)
+		if msg.ID != nil {
+			replies[string(*msg.ID)] = string(data)
+		} else {
+			notes = append(notes, string(msg.Params))
+		}
+	}
+
+	checks := []struct {
+		got, want string
+	}{
+		{replies["1"], `"hoverProvider":true`},
+		{notes[0], `"message":"port: out of range: 0"`},
+		{notes[0], `"message":"missing required key: id"`},
+		{replies["2"], `"label":"debug"`},
+		{replies["3"], `**port** `},
+		{replies["3"], `Listen port`},
+		{replies["4"], `"start":{"character":0,"line":1}`},
+		{replies["4"], `base.ini"}]`},
+		{notes[1], `"diagnostics":[]`},
+		{replies["5"], `"newText":"id = a\n@include base.ini\n\n[serverHttp]\n    port = 8080\n"`},
+		{replies["6"], `null`},
+	}
+	for _, check := range checks {
+		if !strings.Contains(check.got, check.want) {
+			t.Errorf("Expected %s in:\n%s", check.want, check.got)
+		}
+	}
+}
+
+// Test positions counted in UTF-16 code units
+func TestUTF16Positions(t *testing.T) {
+	line := "a = é😀x"
+	for _, c := range []struct{ bytes, units int }{{0, 0}, {4, 4}, {6, 5}, {10, 7}, {11, 8}, {99, 8}} {
+		if got := toUTF16(line, c.bytes); got != c.units {
+			t.Errorf("Expected byte %v at character %v, got %v", c.bytes, c.units, got)
+		}
+		if c.bytes <= len(line) {
+			if got := fromUTF16(line, c.units); got != c.bytes {
+				t.Errorf("Expected character %v at byte %v, got %v", c.units, c.bytes, got)
+			}
+		}
+	}
+
+	s := newServer(&bytes.Buffer{})
+	s.docs["x"] = "[sé😀\nk = v\n"
+	diags := s.diagnostics("x")
+	if len(diags) == 0 || diags[0].Range.End.Character != 5 {
+		t.Errorf("Expected diagnostic ending at character 5, got %+v", diags)
+	}
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/deze333/skini"
+)
+
+// Deepest include chain followed for definitions
+const maxIncludeDepth = 16
+
+// Server keeps open documents and schema
+type server struct {
+	out    io.Writer
+	schema *skini.Schema
+
+	// Text of open documents by URI
+	docs map[string]string
+}
+
+func newServer(out io.Writer) *server {
+	return &server{out: out, docs: map[string]string{}}
+}
+
+// Loads schema from JSON file.
+func (s *server) loadSchema(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	s.schema, err = skini.ReadSchema(data)
+	return err
+}
+
+// Serves messages until exit notification or end of input.
+func (s *server) serve(r io.Reader) error {
+	in := bufio.NewReader(r)
+	for {
+		msg, err := readMessage(in)
+		if err == io.EOF {
+			return nil
+		}
+		var rerr *rpcError
+		if errors.As(err, &rerr) {
+			s.reply(nil, nil, rerr)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, rerr := s.handle(msg)
+		if msg.ID != nil {
+			s.reply(msg.ID, result, rerr)
+		}
+	}
+}
+
+// Writes response, null result unless error.
+func (s *server) reply(id *json.RawMessage, result interface{}, rerr *rpcError) {
+	resp := &message{ID: id, Error: rerr}
+	if rerr == nil {
+		if result == nil {
+			result = json.RawMessage("null")
+		}
+		resp.Result = result
+	}
+	writeMessage(s.out, resp)
+}
+
+// Sends notification.
+func (s *server) notify(method string, params interface{}) {
+	data, _ := json.Marshal(params)
+	writeMessage(s.out, &message{Method: method, Params: data})
+}
+
+// Dispatches request or notification.
+func (s *server) handle(msg *message) (result interface{}, rerr *rpcError) {
+	decode := func(v interface{}) bool {
+		if err := json.Unmarshal(msg.Params, v); err != nil {
+			rerr = &rpcError{codeInvalidParams, err.Error()}
+			return false
+		}
+		return true
+	}
+
+	switch msg.Method {
+	case "initialize":
+		var p initializeParams
+		if !decode(&p) {
+			return
+		}
+		if p.InitializationOptions.Schema != "" {
+			if err := s.loadSchema(p.InitializationOptions.Schema); err != nil {
+				return nil, &rpcError{codeInvalidParams, err.Error()}
+			}
+		}
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           1,
+				"completionProvider":         map[string]interface{}{"triggerCharacters": []string{"["}},
+				"hoverProvider":              true,
+				"definitionProvider":         true,
+				"documentFormattingProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "skini-lsp"},
+		}, nil
+
+	case "initialized", "shutdown", "$/cancelRequest":
+		return
+
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if decode(&p) {
+			s.docs[p.TextDocument.URI] = p.TextDocument.Text
+			s.publish(p.TextDocument.URI)
+		}
+		return
+
+	case "textDocument/didChange":
+		var p didChangeParams
+		if decode(&p) && len(p.ContentChanges) > 0 {
+			s.docs[p.TextDocument.URI] = p.ContentChanges[len(p.ContentChanges)-1].Text
+			s.publish(p.TextDocument.URI)
+		}
+		return
+
+	case "textDocument/didClose":
+		var p didCloseParams
+		if decode(&p) {
+			delete(s.docs, p.TextDocument.URI)
+			s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []diagnostic{}})
+		}
+		return
+
+	case "textDocument/completion":
+		var p positionParams
+		if decode(&p) {
+			result = s.completion(s.docs[p.TextDocument.URI], p.Position)
+		}
+		return
+
+	case "textDocument/hover":
+		var p positionParams
+		if decode(&p) {
+			if h := s.hover(s.docs[p.TextDocument.URI], p.Position); h != nil {
+				result = h
+			}
+		}
+		return
+
+	case "textDocument/definition":
+		var p positionParams
+		if decode(&p) {
+			result = s.definition(p.TextDocument.URI, p.Position)
+		}
+		return
+
+	case "textDocument/formatting":
+		var p formattingParams
+		if decode(&p) {
+			result = s.format(s.docs[p.TextDocument.URI])
+		}
+		return
+	}
+
+	if msg.ID != nil && !strings.HasPrefix(msg.Method, "$/") {
+		rerr = &rpcError{codeMethodNotFound, "method not found: " + msg.Method}
+	}
+	return
+}
+
+//------------------------------------------------------------
+// Diagnostics
+//------------------------------------------------------------
+
+// Publishes diagnostics of open document.
+func (s *server) publish(uri string) {
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: s.diagnostics(uri)})
+}
+
+// Parse error, schema violations and missing includes.
+func (s *server) diagnostics(uri string) []diagnostic {
+	text := s.docs[uri]
+	lines := strings.Split(text, "\n")
+	diags := []diagnostic{}
+	add := func(line, col int, severity int, msg string) {
+		if line < 1 || line > len(lines) {
+			line = 1
+		}
+		src := lines[line-1]
+		start := 0
+		if col > 0 {
+			start = toUTF16(src, col-1)
+		}
+		diags = append(diags, diagnostic{
+			Range:    lspRange{position{line - 1, start}, position{line - 1, toUTF16(src, len(src))}},
+			Severity: severity,
+			Source:   "skini",
+			Message:  msg,
+		})
+	}
+
+	doc, err := skini.ReadDocument(strings.NewReader(text))
+	if err != nil {
+		var perr *skini.ParseError
+		if errors.As(err, &perr) {
+			add(perr.Line, perr.Col, severityError, perr.Err.Error())
+		} else {
+			add(1, 0, severityError, err.Error())
+		}
+		return diags
+	}
+
+	if s.schema != nil {
+		var verr *skini.ValidationError
+		if errors.As(s.schema.Validate(doc, ""), &verr) {
+			for _, e := range verr.Errors {
+				add(e.Line, e.Col, severityError, e.Err.Error())
+			}
+		}
+	}
+
+	for _, b := range doc.Blocks {
+		for _, e := range b.Entries {
+			if e.Kind != skini.EntryInclude {
+				continue
+			}
+			if _, err := s.readFile(resolveInclude(uri, e.Value)); err != nil {
+				add(e.Line, 0, severityWarning, "included file not found: "+e.Value)
+			}
+		}
+	}
+	return diags
+}
+
+//------------------------------------------------------------
+// Completion and hover
+//------------------------------------------------------------
+
+// Completes section names after '[', values of enums after
+// '=' and keys of current block elsewhere.
+func (s *server) completion(text string, pos position) []completionItem {
+	items := []completionItem{}
+	if s.schema == nil {
+		return items
+	}
+	prefix := lineAt(text, pos.Line)
+	prefix = prefix[:fromUTF16(prefix, pos.Character)]
+	prefix = strings.TrimLeft(prefix, " \t")
+
+	// Sections and maps
+	if strings.HasPrefix(prefix, "[") {
+		for _, name := range sortedNames(s.schema.Properties) {
+			prop := s.schema.Properties[name]
+			if prop.Type != "object" {
+				continue
+			}
+			items = append(items, completionItem{Label: name, Kind: kindModule, Documentation: prop.Description, InsertText: name + "]"})
+		}
+		return items
+	}
+
+	obj := s.objectAt(text, pos.Line)
+	if obj == nil {
+		return items
+	}
+
+	// Enum values
+	if key, _, ok := strings.Cut(prefix, "="); ok {
+		prop := obj.Property(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(key), "+")))
+		if prop == nil {
+			return items
+		}
+		if prop.Items != nil {
+			prop = prop.Items
+		}
+		for _, value := range prop.Enum {
+			items = append(items, completionItem{Label: value, Kind: kindProperty})
+		}
+		return items
+	}
+
+	// Keys
+	for _, name := range sortedNames(obj.Properties) {
+		prop := obj.Properties[name]
+		if prop.Type == "object" {
+			continue
+		}
+		items = append(items, completionItem{Label: name, Kind: kindProperty, Detail: prop.Type, Documentation: prop.Description, InsertText: name + " = "})
+	}
+	return items
+}
+
+// Describes key or section under cursor.
+func (s *server) hover(text string, pos position) *hover {
+	if s.schema == nil {
+		return nil
+	}
+	b, e := entryAt(lineAt(text, pos.Line))
+	var name string
+	var prop, parent *skini.Schema
+	switch {
+	case e != nil && e.Kind != skini.EntryInclude:
+		parent = s.objectAt(text, pos.Line)
+		if parent != nil {
+			name, prop = e.Key, parent.Property(e.Key)
+		}
+	case b != nil:
+		parent = s.schema
+		name, prop = b.Header(), s.blockSchema(b)
+	}
+	if prop == nil {
+		return nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**%s**", name)
+	if prop.Type != "" {
+		fmt.Fprintf(&sb, " `%s`", prop.Type)
+	}
+	if prop.Description != "" {
+		sb.WriteString("\n\n" + prop.Description)
+	}
+	if prop.Default != nil {
+		def, _ := json.Marshal(prop.Default)
+		fmt.Fprintf(&sb, "\n\nDefault: `%s`", def)
+	}
+	if len(prop.Enum) > 0 {
+		fmt.Fprintf(&sb, "\n\nOne of: `%s`", strings.Join(prop.Enum, "`, `"))
+	}
+	for _, req := range parent.Required {
+		if e != nil && req == e.Key {
+			sb.WriteString("\n\nRequired")
+		}
+	}
+	return &hover{Contents: markupContent{Kind: "markdown", Value: sb.String()}}
+}
+
+// Schema of object that line belongs to, nil if unknown.
+func (s *server) objectAt(text string, line int) *skini.Schema {
+	if b := s.blockAt(text, line); b != nil {
+		return s.blockSchema(b)
+	}
+	return s.schema
+}
+
+// Schema of section, map or submap.
+func (s *server) blockSchema(b *skini.Block) *skini.Schema {
+	switch {
+	case b.Kind == skini.BlockSection:
+		return s.schema.Property(b.Name)
+	case b.Kind == skini.BlockMap && b.Key == "":
+		return s.schema.Property("map." + b.Name)
+	case b.Kind == skini.BlockMap:
+		if m := s.schema.Property("map." + b.Name); m != nil {
+			return m.AdditionalProperties
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------
+// Definition and formatting
+//------------------------------------------------------------
+
+// Included file for @include line. For key, its other
+// definitions in document and files it includes.
+func (s *server) definition(uri string, pos position) []location {
+	locs := []location{}
+	text := s.docs[uri]
+	_, e := entryAt(lineAt(text, pos.Line))
+	if e == nil {
+		return locs
+	}
+	if e.Kind == skini.EntryInclude {
+		path := resolveInclude(uri, e.Value)
+		if _, err := s.readFile(path); err == nil {
+			locs = append(locs, location{URI: pathToURI(path), Range: lspRange{}})
+		}
+		return locs
+	}
+
+	header := ""
+	if b := s.blockAt(text, pos.Line); b != nil {
+		header = b.Header()
+	}
+	seen := map[string]bool{}
+	s.findKey(uri, text, header, e.Key, 0, seen, &locs)
+
+	// Not the definition under cursor itself
+	out := locs[:0]
+	for _, loc := range locs {
+		if loc.URI != uri || loc.Range.Start.Line != pos.Line {
+			out = append(out, loc)
+		}
+	}
+	return out
+}
+
+// Collects definitions of key in block with header,
+// following includes.
+func (s *server) findKey(uri, text, header, key string, depth int, seen map[string]bool, locs *[]location) {
+	if seen[uri] || depth > maxIncludeDepth {
+		return
+	}
+	seen[uri] = true
+
+	doc, err := skini.ReadDocument(strings.NewReader(text))
+	if err != nil {
+		return
+	}
+	for _, b := range doc.Blocks {
+		for _, e := range b.Entries {
+			switch {
+			case e.Kind == skini.EntryInclude:
+				path := resolveInclude(uri, e.Value)
+				if data, err := s.readFile(path); err == nil {
+					s.findKey(pathToURI(path), data, header, key, depth+1, seen, locs)
+				}
+			case b.Header() == header && e.Key == key:
+				line := lspRange{Start: position{e.Line - 1, 0}, End: position{e.Line - 1, 0}}
+				*locs = append(*locs, location{URI: uri, Range: line})
+			}
+		}
+	}
+}
+
+// Block header line belongs to, nil for root.
+func (s *server) blockAt(text string, line int) *skini.Block {
+	lines := strings.Split(text, "\n")
+	for i := line; i >= 0 && i < len(lines); i-- {
+		if b, _ := entryAt(lines[i]); b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+// Rewrites document in default style of skini.Format. No edits if
+// document has errors.
+func (s *server) format(text string) []textEdit {
+	data, err := skini.Format([]byte(text), nil)
+	if err != nil {
+		return []textEdit{}
+	}
+	formatted := string(data)
+	if formatted == text {
+		return []textEdit{}
+	}
+	lines := strings.Split(text, "\n")
+	last := lines[len(lines)-1]
+	end := position{len(lines) - 1, toUTF16(last, len(last))}
+	return []textEdit{{Range: lspRange{End: end}, NewText: formatted}}
+}
+
+//------------------------------------------------------------
+// Helpers
+//------------------------------------------------------------
+
+// Parses single line as header or entry.
+func entryAt(line string) (b *skini.Block, e *skini.Entry) {
+	doc, err := skini.ReadDocument(strings.NewReader(strings.TrimSpace(line)))
+	if err != nil {
+		return
+	}
+	if len(doc.Blocks) > 1 {
+		return doc.Blocks[1], nil
+	}
+	if entries := doc.Blocks[0].Entries; len(entries) > 0 {
+		return nil, entries[0]
+	}
+	return
+}
+
+// Gets line of text, empty if out of range.
+func lineAt(text string, line int) string {
+	lines := strings.Split(text, "\n")
+	if line < 0 || line >= len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line], "\r")
+}
+
+// Converts byte offset in line to UTF-16 code units, as LSP counts characters.
+func toUTF16(line string, offset int) int {
+	if offset > len(line) {
+		offset = len(line)
+	}
+	n := 0
+	for _, r := range line[:offset] {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// Converts UTF-16 character in line to byte offset, clamped to line length.
+func fromUTF16(line string, char int) int {
+	n := 0
+	for i, r := range line {
+		if n >= char {
+			return i
+		}
+		n += utf16.RuneLen(r)
+	}
+	return len(line)
+}
+
+// Reads open document or file from disk.
+func (s *server) readFile(path string) (string, error) {
+	if text, ok := s.docs[pathToURI(path)]; ok {
+		return text, nil
+	}
+	data, err := os.ReadFile(path)
+	return string(data), err
+}
+
+// Path of include relative to including document.
+func resolveInclude(uri, include string) string {
+	if filepath.IsAbs(include) {
+		return include
+	}
+	return filepath.Join(filepath.Dir(uriToPath(uri)), include)
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// Gets property names in sorted order.
+func sortedNames(props map[string]*skini.Schema) []string {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,340 @@
+// Command skini inspects improved INI files.
+//
+// Usage:
+//
+//	skini meta [-dialect name] file...
+//	skini check [-schema file.json] file...
+//	skini fmt [-l] [-w] [-config path] [-align] [-sort] [-width n] [-indent n] file...
+//	skini diff [-json] old.ini new.ini
+//	skini dump-defaults
+//
+// Commands:
+//
+//	meta    prints origin of every key and keys left unused
+//	check   checks syntax of files, and their keys against
+//	        JSON Schema if given
+//	fmt     formats files, prints result unless -l or -w
+//	diff    prints keys added, removed and changed between
+//	        files, exits with status 1 if there are any
+//	dump-defaults
+//	        prints built-in defaults of commands
+//
+// Defaults of fmt flags are read from built-in defaults,
+// overridden by file or *.ini files of directory given
+// with -config.
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/deze333/skini"
+)
+
+// Commands by name
+var commands = map[string]func(args []string) error{
+	"meta":          runMeta,
+	"check":         runCheck,
+	"fmt":           runFmt,
+	"diff":          runDiff,
+	"dump-defaults": runDumpDefaults,
+}
+
+//go:embed defaults.ini
+var defaultsFS embed.FS
+
+// Built-in defaults of commands
+var defaults = &skini.Defaults{FS: defaultsFS, Name: "defaults.ini"}
+
+// Settings of commands
+type config struct {
+	Fmt struct {
+		Indent int
+		Align  bool
+		Sort   bool
+		Width  int
+	}
+}
+
+// Reads defaults overridden by file or *.ini files of
+// directory at path, if given.
+func loadConfig(path string) (cfg *config, err error) {
+	var paths []string
+	if path != "" {
+		if _, err = os.Stat(path); err != nil {
+			return
+		}
+		paths = append(paths, path)
+	}
+	cfg = &config{}
+	_, err = defaults.Parse(cfg, nil, paths...)
+	return
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		usage()
+		os.Exit(2)
+	}
+	if err := commands[os.Args[1]](os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: skini meta [-dialect name] file...")
+	fmt.Fprintln(os.Stderr, "       skini check [-schema file.json] file...")
+	fmt.Fprintln(os.Stderr, "       skini fmt [-l] [-w] [-config path] [-align] [-sort] [-width n] [-indent n] file...")
+	fmt.Fprintln(os.Stderr, "       skini diff [-json] old.ini new.ini")
+	fmt.Fprintln(os.Stderr, "       skini dump-defaults")
+}
+
+//------------------------------------------------------------
+// Commands
+//------------------------------------------------------------
+
+// Parses files in order as if they were one and prints
+// origin of every key. Keys set by earlier files and
+// replaced by later ones are listed as unused.
+func runMeta(args []string) (err error) {
+	fs := flag.NewFlagSet("meta", flag.ExitOnError)
+	dialect := dialectFlag(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	meta := &skini.Meta{}
+	opts := &skini.Options{Meta: meta, Includes: true}
+	if opts.Dialect, err = parseDialect(*dialect); err != nil {
+		return
+	}
+
+	m := map[string]interface{}{}
+	for _, filename := range fs.Args() {
+		if err = skini.ParseFileWith(&m, filename, opts); err != nil {
+			return
+		}
+	}
+	fmt.Print(meta)
+	return
+}
+
+// Reads every file and validates it against schema if
+// given. Prints all problems, fails if there were any.
+func runCheck(args []string) (err error) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	schemaFile := fs.String("schema", "", "JSON Schema to validate against")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var schema *skini.Schema
+	if *schemaFile != "" {
+		data, err := os.ReadFile(*schemaFile)
+		if err != nil {
+			return err
+		}
+		if schema, err = skini.ReadSchema(data); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, filename := range fs.Args() {
+		if err := checkFile(filename, schema); err != nil {
+			fmt.Println(err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v file(s) failed", failed, fs.NArg())
+	}
+	return
+}
+
+// Reads and validates single file.
+func checkFile(filename string, schema *skini.Schema) error {
+	doc, err := readDocument(filename)
+	if err != nil || schema == nil {
+		return err
+	}
+	return schema.Validate(doc, filename)
+}
+
+// Reads document of file, parse errors name the file.
+func readDocument(filename string) (*skini.Document, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	doc, err := skini.ReadDocument(file)
+	if err != nil {
+		var perr *skini.ParseError
+		if errors.As(err, &perr) {
+			perr.File = filename
+		}
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Formats files like gofmt: prints formatted input,
+// lists files whose formatting differs with -l and
+// rewrites them with -w.
+func runFmt(args []string) (err error) {
+	// Config is known once flags are parsed, they are
+	// then parsed again over defaults it sets
+	flags, err := parseFmtFlags(args, "")
+	if err == nil && *flags.config != "" {
+		flags, err = parseFmtFlags(args, *flags.config)
+	}
+	if err != nil {
+		return
+	}
+	fs, list, write, style := flags.fs, flags.list, flags.write, flags.style
+	if fs.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, filename := range fs.Args() {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		out, err := skini.Format(src, style)
+		if err != nil {
+			var perr *skini.ParseError
+			if errors.As(err, &perr) {
+				perr.File = filename
+			}
+			return err
+		}
+
+		changed := !bytes.Equal(src, out)
+		if *list && changed {
+			fmt.Println(filename)
+		}
+		if *write && changed {
+			if err = os.WriteFile(filename, out, 0644); err != nil {
+				return err
+			}
+		}
+		if !*list && !*write {
+			os.Stdout.Write(out)
+		}
+	}
+	return
+}
+
+// Flags of fmt command
+type fmtFlags struct {
+	fs     *flag.FlagSet
+	config *string
+	list   *bool
+	write  *bool
+	style  *skini.FormatStyle
+}
+
+// Parses fmt flags with defaults overridden by config at path.
+func parseFmtFlags(args []string, path string) (f *fmtFlags, err error) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return
+	}
+
+	f = &fmtFlags{fs: flag.NewFlagSet("fmt", flag.ExitOnError), style: &skini.FormatStyle{}}
+	f.config = f.fs.String("config", "", "file, or directory of *.ini files, overriding defaults of flags")
+	f.list = f.fs.Bool("l", false, "list files whose formatting differs")
+	f.write = f.fs.Bool("w", false, "write result to file instead of stdout")
+	f.fs.BoolVar(&f.style.AlignEquals, "align", cfg.Fmt.Align, "align = within blocks")
+	f.fs.BoolVar(&f.style.SortKeys, "sort", cfg.Fmt.Sort, "sort keys within blocks")
+	f.fs.IntVar(&f.style.Width, "width", cfg.Fmt.Width, "wrap += text at width, 0 for one line")
+	f.fs.IntVar(&f.style.Indent, "indent", cfg.Fmt.Indent, "indent of section keys")
+	f.fs.Parse(args)
+	return
+}
+
+// Compares two files by key path. Prints changes one per
+// line, or as JSON array with -json.
+func runDiff(args []string) (err error) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print changes as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var docs [2]*skini.Document
+	for i, filename := range fs.Args() {
+		if docs[i], err = readDocument(filename); err != nil {
+			return
+		}
+	}
+	changes, err := skini.Diff(docs[0], docs[1])
+	if err != nil {
+		return
+	}
+
+	if *asJSON {
+		if changes == nil {
+			changes = skini.Changes{}
+		}
+		data, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		fmt.Print(changes)
+	}
+	if len(changes) > 0 {
+		os.Exit(1)
+	}
+	return
+}
+
+// Prints built-in defaults.
+func runDumpDefaults(args []string) (err error) {
+	if len(args) > 0 {
+		usage()
+		os.Exit(2)
+	}
+	_, err = defaults.WriteTo(os.Stdout)
+	return
+}
+
+//------------------------------------------------------------
+// Flags
+//------------------------------------------------------------
+
+// Adds -dialect flag.
+func dialectFlag(fs *flag.FlagSet) *string {
+	return fs.String("dialect", "improved", "input syntax: improved, configparser or git")
+}
+
+// Gets dialect by name.
+func parseDialect(name string) (skini.Dialect, error) {
+	switch name {
+	case "improved":
+		return skini.DialectImproved, nil
+	case "configparser":
+		return skini.DialectConfigParser, nil
+	case "git":
+		return skini.DialectGitConfig, nil
+	}
+	return 0, fmt.Errorf("error, unknown dialect: %s", name)
+}
@@ -0,0 +1,361 @@
+package skini
+
+/*
+Convert -- converts documents to and from other formats
+through a tree of ordered keys. Both directions report
+constructs that the other side cannot represent.
+
+Tree shape, same for all formats:
+  key = value          "key": "value"
+  key = list...        "key": ["a", "b"]
+  key = @null          "key": null
+  [section]            "section": {...}
+  [map.name]           "map.name": {...}
+  [map.name | key]     "map.name": {"key": {...}}
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+//------------------------------------------------------------
+// Conversion errors
+//------------------------------------------------------------
+
+// Convert error lists constructs that target format cannot
+// represent. Converted output is still returned along with it,
+// less the constructs listed.
+type ConvertError struct {
+	// Target format: INI, JSON, TOML or YAML
+	Format string
+
+	// Lost constructs, one per line of source
+	Losses []string
+}
+
+func (e *ConvertError) Error() string {
+	return fmt.Sprintf("error, cannot represent in %s: %s", e.Format, strings.Join(e.Losses, "; "))
+}
+
+// Collects losses of conversion into format.
+type losses struct {
+	format string
+	list   []string
+}
+
+// Records lost construct at source line, 0 if unknown.
+func (ls *losses) add(line int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if line > 0 {
+		msg = fmt.Sprintf("line %v: %s", line, msg)
+	}
+	ls.list = append(ls.list, msg)
+}
+
+// Returns error if anything was lost.
+func (ls *losses) err() error {
+	if len(ls.list) == 0 {
+		return nil
+	}
+	return &ConvertError{Format: ls.format, Losses: ls.list}
+}
+
+//------------------------------------------------------------
+// Tree
+//------------------------------------------------------------
+
+// Tree of ordered keys. Values are string, []string,
+// nil for null, *tree or lost for values that
+// have no place in a document.
+type tree struct {
+	keys     []string
+	values   map[string]interface{}
+	comments map[string][]string
+	lines    map[string]int
+
+	// Comments after last key
+	trailing []string
+}
+
+// Value read from other format that cannot be converted
+type lost string
+
+func newTree() *tree {
+	return &tree{values: map[string]interface{}{}, comments: map[string][]string{}, lines: map[string]int{}}
+}
+
+// Sets value of key keeping position of existing key.
+func (t *tree) set(key string, v interface{}, line int) {
+	if _, ok := t.values[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.values[key] = v
+	t.lines[key] = line
+}
+
+// Removes key.
+func (t *tree) remove(key string) {
+	if _, ok := t.values[key]; !ok {
+		return
+	}
+	delete(t.values, key)
+	for i, k := range t.keys {
+		if k == key {
+			t.keys = append(t.keys[:i], t.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Gets subtree of key, creating it on first use.
+// Returns nil if key holds something else.
+func (t *tree) subtree(key string, line int) *tree {
+	switch v := t.values[key].(type) {
+	case *tree:
+		return v
+	case nil:
+		if _, ok := t.values[key]; !ok {
+			sub := newTree()
+			t.set(key, sub, line)
+			return sub
+		}
+	}
+	return nil
+}
+
+// Sets value of key unless it would replace subtree
+// or the other way round.
+func (t *tree) put(key string, v interface{}, line int, ls *losses) {
+	old, exists := t.values[key]
+	_, oldTree := old.(*tree)
+	_, newTree := v.(*tree)
+	if exists && oldTree != newTree {
+		ls.add(line, "key %s conflicts with line %v", key, t.lines[key])
+		return
+	}
+	t.set(key, v, line)
+}
+
+//------------------------------------------------------------
+// Document to tree
+//------------------------------------------------------------
+
+// What target format supports besides strings and lists
+type formatCaps struct {
+	name     string
+	nulls    bool
+	comments bool
+}
+
+// Converts document into tree of target format.
+func docToTree(doc *Document, caps formatCaps, ls *losses) *tree {
+	root := newTree()
+
+	// Keeps comments or reports them
+	comment := func(t *tree, key string, line int, comments []string) {
+		if len(comments) == 0 {
+			return
+		}
+		if !caps.comments {
+			ls.add(line, "%v comment line(s) above %s", len(comments), key)
+			return
+		}
+		t.comments[key] = append(t.comments[key], comments...)
+	}
+
+	for _, b := range doc.Blocks {
+		t := root
+		switch b.Kind {
+		case BlockSection:
+			comment(root, b.Name, b.Line, b.Comments)
+			t = root.subtree(b.Name, b.Line)
+		case BlockMap:
+			name := "map." + b.Name
+			comment(root, name, b.Line, b.Comments)
+			if t = root.subtree(name, b.Line); t != nil && b.Key != "" {
+				t = t.subtree(b.Key, b.Line)
+			}
+		}
+		if t == nil {
+			ls.add(b.Line, "%s conflicts with key of same name", b.Header())
+			continue
+		}
+
+		for _, e := range b.Entries {
+			comment(t, e.Key, e.Line, e.Comments)
+			switch {
+			case e.Kind == EntryInclude:
+				ls.add(e.Line, "@include %s", e.Value)
+			case e.Kind == EntryList && len(e.Items) > 0:
+				t.put(e.Key, append([]string{}, e.Items...), e.Line, ls)
+			case e.IsNull() && caps.nulls:
+				t.put(e.Key, nil, e.Line, ls)
+			case e.IsNull():
+				// Unsetting key is as good as not having it
+				t.remove(e.Key)
+				ls.add(e.Line, "%s = %s", e.Key, nullValue)
+			default:
+				t.put(e.Key, e.Value, e.Line, ls)
+			}
+		}
+	}
+
+	if len(doc.Comments) > 0 {
+		if caps.comments {
+			root.trailing = doc.Comments
+		} else {
+			ls.add(0, "%v comment line(s) at end", len(doc.Comments))
+		}
+	}
+	return root
+}
+
+//------------------------------------------------------------
+// Tree to document
+//------------------------------------------------------------
+
+// Converts tree read from other format into document.
+func treeToDoc(root *tree, ls *losses) *Document {
+	doc := &Document{Blocks: []*Block{{Kind: BlockRoot}}, Comments: root.trailing}
+
+	for _, key := range root.keys {
+		line := root.lines[key]
+		sub, ok := root.values[key].(*tree)
+		if !ok {
+			addEntry(doc.Blocks[0], root, key, ls)
+			continue
+		}
+
+		// Map
+		if strings.HasPrefix(key, "map.") {
+			name := key[len("map."):]
+			if n, _, ok := splitMap(key); !ok || n != name {
+				ls.add(line, "%s is not a valid map name", key)
+				continue
+			}
+			doc.Blocks = append(doc.Blocks, mapBlocks(name, root.comments[key], sub, ls)...)
+			continue
+		}
+
+		// Section
+		if n, ok := splitSection(key); !ok || n != key {
+			ls.add(line, "%s is not a valid section name", key)
+			continue
+		}
+		b := &Block{Kind: BlockSection, Name: key, Comments: root.comments[key]}
+		for _, k := range sub.keys {
+			if _, ok := sub.values[k].(*tree); ok {
+				ls.add(sub.lines[k], "%s.%s nested in section", key, k)
+				continue
+			}
+			addEntry(b, sub, k, ls)
+		}
+		doc.Blocks = append(doc.Blocks, b)
+	}
+	return doc
+}
+
+// Converts tree of map into [map.name] block followed
+// by [map.name | key] block of every submap.
+func mapBlocks(name string, comments []string, t *tree, ls *losses) (blocks []*Block) {
+	top := &Block{Kind: BlockMap, Name: name, Comments: comments}
+	blocks = append(blocks, top)
+
+	for _, key := range t.keys {
+		sub, ok := t.values[key].(*tree)
+		if !ok {
+			addEntry(top, t, key, ls)
+			continue
+		}
+
+		if _, k, ok := splitMap("map." + name + " | " + key); !ok || k != key {
+			ls.add(t.lines[key], "%s is not a valid key of map.%s", key, name)
+			continue
+		}
+		b := &Block{Kind: BlockMap, Name: name, Key: key, Comments: t.comments[key]}
+		for _, k := range sub.keys {
+			if _, ok := sub.values[k].(*tree); ok {
+				ls.add(sub.lines[k], "map.%s.%s.%s nested too deep", name, key, k)
+				continue
+			}
+			addEntry(b, sub, k, ls)
+		}
+		blocks = append(blocks, b)
+	}
+
+	// Map header is needed only for its own keys
+	if len(top.Entries) == 0 && len(top.Comments) == 0 && len(blocks) > 1 {
+		blocks = blocks[1:]
+	}
+	return
+}
+
+// Adds value of key in tree as entry of block.
+func addEntry(b *Block, t *tree, key string, ls *losses) {
+	line := t.lines[key]
+	if !isValidKey(key) {
+		ls.add(line, "%q is not a valid key", key)
+		return
+	}
+	e := &Entry{Kind: EntryValue, Key: key, Line: line, Comments: t.comments[key]}
+
+	switch v := t.values[key].(type) {
+	case nil:
+		e.Value = nullValue
+	case string:
+		if !isValidValue(v) {
+			ls.add(line, "value of %s cannot be written on one line", key)
+			return
+		}
+		if v == nullValue {
+			ls.add(line, "value of %s would read as null", key)
+			return
+		}
+		e.Value = v
+	case []string:
+		for _, item := range v {
+			if !isValidItem(item) {
+				ls.add(line, "item %q of %s cannot be written as list item", item, key)
+				return
+			}
+		}
+		if len(v) > 0 {
+			e.Kind, e.Items = EntryList, v
+		}
+	case lost:
+		ls.add(line, "%s of %s", v, key)
+		return
+	}
+	b.Entries = append(b.Entries, e)
+}
+
+// Validity is decided by the lexer: written line
+// must read back exactly as it was.
+
+// Is key written as 'key = x' read back as the same key ?
+func isValidKey(key string) bool {
+	var tok token
+	classify(&tok, key+" = x")
+	return tok.typ == tokKeyValue && tok.name == key && !tok.plus && key == strings.TrimSpace(key)
+}
+
+// Is value written as 'k = value' read back as the same value ?
+func isValidValue(value string) bool {
+	if value == "" {
+		return true
+	}
+	var tok token
+	classify(&tok, "k = "+value)
+	return tok.value == value && !strings.ContainsAny(value, "\n\r") && value == strings.TrimRight(value, " \t")
+}
+
+// Is item written on its own line read back as the same item ?
+func isValidItem(item string) bool {
+	if item == "" || item != strings.Trim(item, " \t") || strings.ContainsAny(item, "\n\r") {
+		return false
+	}
+	var tok token
+	classify(&tok, item)
+	return tok.typ == tokValue
+}
@@ -0,0 +1,119 @@
+package skini
+
+/*
+Defaults -- baseline config shipped inside binary,
+usually embed.FS, overlaid by files on disk:
+
+  //go:embed defaults.ini
+  var defaultsFS embed.FS
+
+  defaults := &skini.Defaults{FS: defaultsFS, Name: "defaults.ini"}
+  overridden, err := defaults.Parse(&cfg, nil, "/etc/app.ini", "/etc/app.d")
+
+Files on disk are parsed in order over defaults. Keys
+they set replace default values, lists replace default
+lists, map entries are added to default maps.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Default config embedded in binary
+type Defaults struct {
+	// File system holding defaults, includes are read from it
+	// whether options enable them or not
+	FS fs.FS
+
+	// Name of defaults file within FS
+	Name string
+}
+
+// Parses defaults, then every on-disk path over them. Path
+// is a file or a directory of *.ini drop-ins parsed in name
+// order, missing path is skipped. Returns origins of keys
+// set by defaults and overridden on disk, sorted by path.
+// Meta of options, if set, gets origins of all files.
+func (d *Defaults) Parse(target interface{}, opts *Options, paths ...string) (overridden []*Origin, err error) {
+	layered := &Options{}
+	if opts != nil {
+		*layered = *opts
+	}
+	if layered.Meta == nil {
+		layered.Meta = &Meta{}
+	}
+	layered.ReplaceLists = true
+	meta := layered.Meta
+
+	// Defaults are part of binary, their includes are trusted
+	embedded := *layered
+	embedded.Includes = true
+	if err = ParseFileFSWith(target, d.FS, d.Name, &embedded); err != nil {
+		return
+	}
+	defaults := make(map[string]*Origin, len(meta.Fields))
+	for key, o := range meta.Fields {
+		defaults[key] = o
+	}
+
+	for _, p := range paths {
+		files, err := dropIns(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if err = ParseFileWith(target, file, layered); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for key, o := range meta.Fields {
+		if old := defaults[key]; old != nil && old != o {
+			overridden = append(overridden, o)
+		}
+	}
+	sort.Slice(overridden, func(i, j int) bool {
+		return overridden[i].Path < overridden[j].Path
+	})
+	return
+}
+
+// Writes defaults file as embedded.
+func (d *Defaults) WriteTo(w io.Writer) (n int64, err error) {
+	data, err := fs.ReadFile(d.FS, d.Name)
+	if err != nil {
+		return 0, fmt.Errorf("error reading defaults: %s", d.Name)
+	}
+	m, err := w.Write(data)
+	return int64(m), err
+}
+
+// Returns file of path, or *.ini files of directory
+// in name order, none if path doesn't exist.
+func dropIns(p string) (files []string, err error) {
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil || !fi.IsDir() {
+		return []string{p}, err
+	}
+
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning directory: %s, error = %s", p, err)
+	}
+	for _, e := range entries {
+		if ok, _ := filepath.Match("*.ini", e.Name()); ok && !e.IsDir() {
+			files = append(files, filepath.Join(p, e.Name()))
+		}
+	}
+	return
+}
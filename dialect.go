@@ -0,0 +1,447 @@
+package skini
+
+/*
+Dialect -- flavours of INI syntax besides improved INI:
+
+ConfigParser, as Python's configparser and Windows:
+  [Any Section Name]
+  key=value, key: value
+  key = first line
+      continuation line     joined with newline
+  %(key)s                   interpolated from keys above
+  [DEFAULT]                 keys inherited by sections below
+
+GitConfig, as git config files:
+  [section]                 section
+  [section "sub"]           map section with submap sub
+  key = "quoted \"value\""  # inline comment
+  flag                      same as flag = true
+  value continues \
+      on next line
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Syntax dialect of input
+type Dialect int
+
+const (
+	// Improved INI of this package. This is the default.
+	DialectImproved Dialect = iota
+
+	// Python configparser and Windows INI
+	DialectConfigParser
+
+	// Git config
+	DialectGitConfig
+)
+
+// Section inherited by all configparser sections
+const defaultSection = "DEFAULT"
+
+// Classifies trimmed not empty line by dialect.
+func (dl Dialect) classify(tok *token, line string) error {
+	switch dl {
+	case DialectConfigParser:
+		classifyConfigParser(tok, line)
+	case DialectGitConfig:
+		return classifyGitConfig(tok, line)
+	default:
+		classify(tok, line)
+	}
+	return nil
+}
+
+//------------------------------------------------------------
+// ConfigParser lines
+//------------------------------------------------------------
+
+// Classifies configparser line. Key ends at first '=' or ':',
+// whitespace around it is optional. Section name is anything
+// within brackets.
+func classifyConfigParser(tok *token, line string) {
+	*tok = token{raw: line}
+
+	// Comment ?
+	if line[0] == '#' || line[0] == ';' {
+		tok.typ = tokComment
+		return
+	}
+
+	// Section ?
+	if line[0] == '[' && line[len(line)-1] == ']' {
+		if name := strings.TrimSpace(line[1 : len(line)-1]); name != "" {
+			tok.typ, tok.name = tokSection, name
+			return
+		}
+	}
+
+	// Key value ?
+	if sep := strings.IndexAny(line, "=:"); sep > 0 {
+		if key := strings.TrimSpace(line[:sep]); key != "" {
+			tok.typ, tok.name, tok.value = tokKeyValue, key, strings.TrimSpace(line[sep+1:])
+			tok.likeKeyValue = true
+			return
+		}
+	}
+
+	// Value
+	tok.typ, tok.value = tokValue, line
+}
+
+//------------------------------------------------------------
+// ConfigParser decoding
+//------------------------------------------------------------
+
+// State of configparser input
+type configParserState struct {
+	// Keys of current section seen so far, lowercased
+	vars map[string]string
+
+	// Keys of DEFAULT section in order, lowercased lookup
+	defaults     []*defaultValue
+	defaultIndex map[string]int
+
+	// Reading DEFAULT section
+	inDefault bool
+}
+
+// Key of DEFAULT section
+type defaultValue struct {
+	name  string
+	value string
+
+	// Where it was defined
+	file string
+	line int
+	raw  string
+
+	// Inherited by any section
+	used bool
+}
+
+// Parses configparser line: interpolates values, keeps
+// DEFAULT section apart and applies it to sections below.
+func (d *decoder) parseConfigParserLine(tok, next *token, state *parserState) (err error) {
+	cp := d.configParser
+	switch tok.typ {
+
+	case tokSection:
+		cp.vars = map[string]string{}
+		cp.inDefault = tok.name == defaultSection
+		if cp.inDefault {
+			err = closeList(d.target, state)
+			state.capSection, state.capMap, state.capSubmap = "", "", ""
+			return
+		}
+		if err = parseLine(d, tok, next, state); err != nil {
+			return
+		}
+		return d.applyDefaults(tok.name)
+
+	case tokKeyValue:
+		if tok.value, err = d.interpolate(tok.value); err != nil {
+			return
+		}
+		name := strings.ToLower(tok.name)
+		if cp.inDefault {
+			def := &defaultValue{name: tok.name, value: tok.value, file: d.file(), line: tok.line, raw: tok.raw}
+			if i, ok := cp.defaultIndex[name]; ok {
+				if d.recorder != nil && d.recorder.meta != nil {
+					d.recorder.meta.unused(cp.defaults[i].origin(keyPath(defaultSection, cp.defaults[i].name)))
+				}
+				cp.defaults[i] = def
+			} else {
+				cp.defaultIndex[name] = len(cp.defaults)
+				cp.defaults = append(cp.defaults, def)
+			}
+			return
+		}
+		cp.vars[name] = tok.value
+
+	case tokValue:
+		if cp.inDefault {
+			return fmt.Errorf("error, value without key: %s", tok.raw)
+		}
+		if tok.value, err = d.interpolate(tok.value); err != nil {
+			return
+		}
+	}
+	return parseLine(d, tok, next, state)
+}
+
+// Sets DEFAULT keys of section that has such fields.
+func (d *decoder) applyDefaults(section string) (err error) {
+	for _, def := range d.configParser.defaults {
+		if !d.target.hasField(section, def.name) {
+			continue
+		}
+		def.used = true
+		if d.recorder != nil {
+			d.recorder.at(def.file, def.line, def.raw, SourceDefault)
+		}
+		if def.value == "" {
+			err = d.target.setEmpty(section, def.name)
+		} else {
+			err = d.target.setField(section, def.name, def.value)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Records DEFAULT keys inherited by no section as unused.
+func (d *decoder) unusedDefaults() {
+	if d.configParser == nil || d.recorder == nil || d.recorder.meta == nil {
+		return
+	}
+	for _, def := range d.configParser.defaults {
+		if !def.used {
+			d.recorder.meta.unused(def.origin(keyPath(defaultSection, def.name)))
+		}
+	}
+}
+
+// Origin of DEFAULT key.
+func (def *defaultValue) origin(path string) *Origin {
+	return &Origin{Path: path, File: def.file, Line: def.line, Raw: def.raw, Source: SourceDefault}
+}
+
+// Joins lines indented deeper than key with newlines.
+// Key with empty value starts a list as in improved INI,
+// except in DEFAULT section.
+func (d *decoder) appendIndented(lex *lexer, tok *token) (err error) {
+	if tok.value == "" && !d.configParser.inDefault {
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tok.value)
+	for {
+		next, err := lex.peek()
+		if err != nil {
+			return err
+		}
+		if next.typ == tokEOF || next.col <= tok.col {
+			break
+		}
+		if next.typ != tokComment {
+			if sb.Len() > 0 {
+				sb.WriteByte('\n')
+			}
+			sb.WriteString(next.raw)
+			if err = d.budget.expansion(sb.Len()); err != nil {
+				return err
+			}
+		}
+		lex.skip()
+	}
+	tok.value = sb.String()
+	return
+}
+
+// Expands %(key)s with value of key above in the same
+// section or in DEFAULT section. %% is a single %.
+func (d *decoder) interpolate(s string) (string, error) {
+	if strings.IndexByte(s, '%') < 0 {
+		return s, nil
+	}
+
+	cp := d.configParser
+	var sb strings.Builder
+	for {
+		i := strings.IndexByte(s, '%')
+		if i < 0 {
+			break
+		}
+		sb.WriteString(s[:i])
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "%%"):
+			sb.WriteByte('%')
+			s = s[2:]
+		case strings.HasPrefix(s, "%("):
+			end := strings.Index(s, ")s")
+			if end < 0 {
+				return "", fmt.Errorf("error, bad interpolation syntax: %s", s)
+			}
+			name := strings.ToLower(s[2:end])
+			value, ok := cp.vars[name]
+			if !ok {
+				if i, found := cp.defaultIndex[name]; found {
+					value, ok = cp.defaults[i].value, true
+				}
+			}
+			if !ok {
+				return "", fmt.Errorf("error, interpolation of key not found: %s", s[2:end])
+			}
+			sb.WriteString(value)
+			s = s[end+2:]
+		default:
+			return "", fmt.Errorf("error, bad interpolation syntax: %s", s)
+		}
+
+		if err := d.budget.expansion(sb.Len()); err != nil {
+			return "", err
+		}
+	}
+	sb.WriteString(s)
+	return sb.String(), d.budget.expansion(sb.Len())
+}
+
+//------------------------------------------------------------
+// GitConfig lines
+//------------------------------------------------------------
+
+// Classifies git config line. Lines continued with
+// backslash are already joined by lexer.
+func classifyGitConfig(tok *token, line string) (err error) {
+	*tok = token{raw: line}
+
+	// Comment ?
+	if line[0] == '#' || line[0] == ';' {
+		tok.typ = tokComment
+		return
+	}
+
+	// Header ?
+	if line[0] == '[' {
+		return splitGitHeader(tok, line)
+	}
+
+	// Key, optionally = value
+	i := 0
+	for i < len(line) && (isAlnum(line[i]) || i > 0 && line[i] == '-') {
+		i++
+	}
+	key, rest := line[:i], strings.TrimLeft(line[i:], " \t")
+	if key == "" || !('a' <= key[0]|32 && key[0]|32 <= 'z') {
+		return fmt.Errorf("error, invalid key: %s", line)
+	}
+
+	tok.typ, tok.name, tok.likeKeyValue = tokKeyValue, key, true
+	switch {
+	case rest == "" || rest[0] == '#' || rest[0] == ';':
+		// Boolean key
+		tok.value = "true"
+	case rest[0] == '=':
+		tok.value, err = gitValue(rest[1:])
+	default:
+		err = fmt.Errorf("error, expected = after key: %s", line)
+	}
+	return
+}
+
+// Splits [section], [section "sub"] and legacy [section.sub].
+// Sections with submap are maps.
+func splitGitHeader(tok *token, line string) (err error) {
+	end := strings.LastIndexByte(line, ']')
+	if end < 0 {
+		return fmt.Errorf("error, invalid section header: %s", line)
+	}
+	if rest := strings.TrimLeft(line[end+1:], " \t"); rest != "" && rest[0] != '#' && rest[0] != ';' {
+		return fmt.Errorf("error, unexpected text after section header: %s", line)
+	}
+	inner := line[1:end]
+
+	// Section name
+	i := 0
+	for i < len(inner) && (isAlnum(inner[i]) || inner[i] == '-' || inner[i] == '.') {
+		i++
+	}
+	name, rest := inner[:i], strings.TrimLeft(inner[i:], " \t")
+	if name == "" {
+		return fmt.Errorf("error, invalid section header: %s", line)
+	}
+
+	// [section "sub"]
+	if rest != "" {
+		if len(rest) < 2 || rest[0] != '"' || rest[len(rest)-1] != '"' || strings.IndexAny(name, ".") >= 0 {
+			return fmt.Errorf("error, invalid section header: %s", line)
+		}
+		sub, err := gitValue(rest)
+		if err != nil {
+			return err
+		}
+		tok.typ, tok.name, tok.value = tokMap, name, sub
+		return nil
+	}
+
+	// [section.sub]
+	if dot := strings.IndexByte(name, '.'); dot > 0 {
+		tok.typ, tok.name, tok.value = tokMap, name[:dot], strings.ToLower(name[dot+1:])
+		return
+	}
+	tok.typ, tok.name = tokSection, name
+	return
+}
+
+// Parses git config value: strips comment and surrounding
+// whitespace outside of quotes, removes quotes and resolves
+// escapes \" \\ \n \t \b.
+func gitValue(s string) (string, error) {
+	var sb strings.Builder
+	quoted := false
+
+	// Whitespace waiting to see if value continues
+	space := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case !quoted && (c == '#' || c == ';'):
+			return sb.String(), nil
+		case !quoted && (c == ' ' || c == '\t'):
+			if sb.Len() > 0 {
+				space++
+			}
+			continue
+		}
+
+		sb.WriteString(strings.Repeat(" ", space))
+		space = 0
+
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\':
+			i++
+			if i == len(s) {
+				return "", fmt.Errorf("error, value ends with backslash")
+			}
+			switch s[i] {
+			case '"', '\\':
+				sb.WriteByte(s[i])
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'b':
+				sb.WriteByte('\b')
+			default:
+				return "", fmt.Errorf("error, invalid escape: \\%c", s[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	if quoted {
+		return "", fmt.Errorf("error, unterminated quote")
+	}
+	return sb.String(), nil
+}
+
+// Does git config line continue on next line ?
+// It does when it ends with odd number of backslashes.
+func gitContinues(line string) bool {
+	n := 0
+	for n < len(line) && line[len(line)-1-n] == '\\' {
+		n++
+	}
+	return n%2 == 1 && line[0] != '#' && line[0] != ';'
+}
@@ -0,0 +1,344 @@
+package skini
+
+/*
+Diff -- semantic difference of two inputs, by key path:
+  key                  root key
+  section.key          key of [section]
+  map.name.key         key of [map.name]
+  map.name|sub.key     key of [map.name | sub]
+  key[2]               list item
+
+Layout, comments and order of keys make no difference,
+nor does spelling of names that match same field, like
+server.http and serverHttp. Includes are not followed.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kind of change
+type ChangeKind int
+
+const (
+	// Key or item only in second input
+	Added ChangeKind = iota
+
+	// Key or item only in first input
+	Removed
+
+	// Value differs
+	Changed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Changed:
+		return "changed"
+	}
+	return fmt.Sprintf("change(%v)", int(k))
+}
+
+// Single change. Lists are shown as [a, b], secrets
+// as ********.
+type Change struct {
+	Kind ChangeKind
+	Path string
+
+	// Value in first input, unless added
+	Old string
+
+	// Value in second input, unless removed
+	New string
+}
+
+// Renders change as line of diff.
+func (c *Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s = %s", c.Path, c.New)
+	case Removed:
+		return fmt.Sprintf("- %s = %s", c.Path, c.Old)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Path, c.Old, c.New)
+}
+
+// Writes kind as its name, old and new only where they apply.
+func (c *Change) MarshalJSON() ([]byte, error) {
+	out := map[string]string{"kind": c.Kind.String(), "path": c.Path}
+	if c.Kind != Added {
+		out["old"] = c.Old
+	}
+	if c.Kind != Removed {
+		out["new"] = c.New
+	}
+	return json.Marshal(out)
+}
+
+// Changes in order of path
+type Changes []*Change
+
+// Renders changes one per line.
+func (cs Changes) String() string {
+	var sb strings.Builder
+	for _, c := range cs {
+		sb.WriteString(c.String() + "\n")
+	}
+	return sb.String()
+}
+
+//------------------------------------------------------------
+// Diff
+//------------------------------------------------------------
+
+// Compares two inputs, each either *Document or struct.
+// Secret fields of structs are compared but not shown.
+func Diff(a, b interface{}) (Changes, error) {
+	return DiffWith(a, b, nil)
+}
+
+// Compares two inputs matching names by strategy of options.
+// Changes show paths as first input spells them.
+func DiffWith(a, b interface{}, opts *Options) (Changes, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	norm, err := opts.Names.normalizer(opts.NameFunc)
+	if err != nil {
+		return nil, err
+	}
+	fa, err := flatten(a, norm)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := flatten(b, norm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Matched paths in order of paths as shown
+	keys := make([]string, 0, len(fa)+len(fb))
+	for key := range fa {
+		keys = append(keys, key)
+	}
+	for key := range fb {
+		if fa[key] == nil {
+			keys = append(keys, key)
+		}
+	}
+	shown := func(key string) string {
+		if v := fa[key]; v != nil {
+			return v.path
+		}
+		return fb[key].path
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return shown(keys[i]) < shown(keys[j])
+	})
+
+	var cs Changes
+	for _, key := range keys {
+		va, vb := fa[key], fb[key]
+		path := shown(key)
+		secret := va != nil && va.secret || vb != nil && vb.secret
+		switch {
+		case va == nil:
+			cs = append(cs, &Change{Kind: Added, Path: path, New: vb.show(secret)})
+		case vb == nil:
+			cs = append(cs, &Change{Kind: Removed, Path: path, Old: va.show(secret)})
+		case va.list && vb.list:
+			cs = append(cs, diffItems(path, va.items, vb.items, secret)...)
+		case va.list != vb.list || va.value != vb.value:
+			cs = append(cs, &Change{Kind: Changed, Path: path, Old: va.show(secret), New: vb.show(secret)})
+		}
+	}
+	return cs, nil
+}
+
+// Value of key path
+type flatValue struct {
+	// Path as written
+	path string
+
+	value string
+	items []string
+	list  bool
+
+	secret bool
+}
+
+// Shows value, list as [a, b].
+func (v *flatValue) show(secret bool) string {
+	switch {
+	case secret:
+		return Redacted
+	case v.list:
+		return "[" + strings.Join(v.items, ", ") + "]"
+	}
+	return v.value
+}
+
+// Gets values of input by key path normalized by norm.
+func flatten(v interface{}, norm func(string) string) (map[string]*flatValue, error) {
+	if doc, ok := v.(*Document); ok {
+		return flattenDocument(doc, nil, norm), nil
+	}
+	m := &marshaler{raw: true, secrets: map[*Entry]bool{}}
+	doc, err := marshalDocument(v, m)
+	if err != nil {
+		return nil, err
+	}
+	return flattenDocument(doc, m.secrets, norm), nil
+}
+
+// Gets values of document by key path normalized by norm.
+// Later keys replace earlier ones, @null removes key.
+func flattenDocument(doc *Document, secrets map[*Entry]bool, norm func(string) string) map[string]*flatValue {
+	flat := map[string]*flatValue{}
+	for _, b := range doc.Blocks {
+		prefix := pathPrefix(b)
+		for _, e := range b.Entries {
+			path := prefix + e.Key
+			key := matchPath(b, e.Key, norm)
+			switch {
+			case e.Kind == EntryInclude:
+			case e.IsNull():
+				delete(flat, key)
+			case e.Kind == EntryList:
+				flat[key] = &flatValue{path: path, items: e.Items, list: true, secret: secrets[e]}
+			default:
+				flat[key] = &flatValue{path: path, value: e.Value, secret: secrets[e]}
+			}
+		}
+	}
+	return flat
+}
+
+// Returns key path of entry with names normalized as they
+// are matched to fields. Keys of maps are data, kept as is.
+func matchPath(b *Block, key string, norm func(string) string) string {
+	switch {
+	case b.Kind == BlockSection:
+		return norm(b.Name) + "." + norm(key)
+	case b.Kind == BlockMap && b.Key == "":
+		return "map." + norm(b.Name) + "." + key
+	case b.Kind == BlockMap:
+		return "map." + norm(b.Name) + "|" + b.Key + "." + key
+	}
+	return norm(key)
+}
+
+// Returns prefix of key paths in block.
+func pathPrefix(b *Block) string {
+	switch {
+	case b.Kind == BlockSection:
+		return b.Name + "."
+	case b.Kind == BlockMap && b.Key == "":
+		return "map." + b.Name + "."
+	case b.Kind == BlockMap:
+		return "map." + b.Name + "|" + b.Key + "."
+	}
+	return ""
+}
+
+// Lists longer than this, multiplied, past common prefix and
+// suffix are compared by position, not by common subsequence
+const maxDiffCells = 1 << 20
+
+// Compares lists item by item along their longest common
+// subsequence. Item replaced by another is changed.
+func diffItems(path string, a, b []string, secret bool) (cs Changes) {
+	show := func(s string) string {
+		if secret {
+			return Redacted
+		}
+		return s
+	}
+
+	// Common prefix and suffix are unchanged
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	na, nb := len(a)-pre-suf, len(b)-pre-suf
+
+	// lcs[i][j] is length of common subsequence of
+	// a[pre+i:], b[pre+j:] within the rest
+	var lcs [][]int32
+	if na*nb <= maxDiffCells {
+		lcs = make([][]int32, na+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, nb+1)
+		}
+		for i := na - 1; i >= 0; i-- {
+			for j := nb - 1; j >= 0; j-- {
+				if a[pre+i] == b[pre+j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+	}
+
+	// Items between matches, removed ones paired with added ones
+	var removed, added []int
+	flush := func() {
+		n := len(removed)
+		if len(added) < n {
+			n = len(added)
+		}
+		for k := 0; k < n; k++ {
+			cs = append(cs, &Change{Kind: Changed, Path: fmt.Sprintf("%s[%v]", path, added[k]), Old: show(a[removed[k]]), New: show(b[added[k]])})
+		}
+		for _, i := range removed[n:] {
+			cs = append(cs, &Change{Kind: Removed, Path: fmt.Sprintf("%s[%v]", path, i), Old: show(a[i])})
+		}
+		for _, j := range added[n:] {
+			cs = append(cs, &Change{Kind: Added, Path: fmt.Sprintf("%s[%v]", path, j), New: show(b[j])})
+		}
+		removed, added = nil, nil
+	}
+
+	// Rest too long for table: items removed and added
+	// are paired by position
+	if lcs == nil {
+		for i := pre; i < len(a)-suf; i++ {
+			removed = append(removed, i)
+		}
+		for j := pre; j < len(b)-suf; j++ {
+			added = append(added, j)
+		}
+		flush()
+		return
+	}
+
+	for i, j := 0, 0; i < na || j < nb; {
+		switch {
+		case i < na && j < nb && a[pre+i] == b[pre+j]:
+			flush()
+			i, j = i+1, j+1
+		case j == nb || i < na && lcs[i+1][j] >= lcs[i][j+1]:
+			removed = append(removed, pre+i)
+			i++
+		default:
+			added = append(added, pre+j)
+			j++
+		}
+	}
+	flush()
+	return
+}
@@ -0,0 +1,269 @@
+package skini
+
+/*
+Document -- syntax tree of input. Keeps blocks, entries
+and comments in the order they were written, so that
+input can be converted, inspected or written back.
+Includes are recorded, not followed.
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//------------------------------------------------------------
+// Document model
+//------------------------------------------------------------
+
+// Block kinds
+type BlockKind int
+
+const (
+	// Keys before first header
+	BlockRoot BlockKind = iota
+
+	// [section]
+	BlockSection
+
+	// [map.name] or [map.name | key]
+	BlockMap
+)
+
+// Entry kinds
+type EntryKind int
+
+const (
+	// key = value, key += value
+	EntryValue EntryKind = iota
+
+	// key = followed by list items
+	EntryList
+
+	// @include path
+	EntryInclude
+)
+
+// Document is input as written. First block is always root.
+type Document struct {
+	Blocks []*Block
+
+	// Comments after last entry
+	Comments []string
+
+	// First error of building
+	err error
+}
+
+// Block is root, [section] or [map.name | key] with its entries.
+type Block struct {
+	Kind BlockKind
+
+	// Section or map name, submap key
+	Name string
+	Key  string
+
+	// Line and column of header, both from 1, 0 for root
+	Line int
+	Col  int
+
+	// Comments above header, as written
+	Comments []string
+
+	Entries []*Entry
+
+	// Document being built and comments for next entry
+	doc     *Document
+	pending []string
+}
+
+// Entry is a single key, list or include.
+type Entry struct {
+	Kind EntryKind
+
+	// Key, value of key or path of include.
+	// Lines of += value are already joined.
+	Key   string
+	Value string
+
+	// List items
+	Items []string
+
+	// Written with +=
+	Append bool
+
+	// Line and column of key or include, both from 1
+	Line int
+	Col  int
+
+	// Comments above entry, as written
+	Comments []string
+}
+
+// Is value @null ?
+func (e *Entry) IsNull() bool {
+	return e.Kind == EntryValue && e.Value == nullValue
+}
+
+//------------------------------------------------------------
+// Reading
+//------------------------------------------------------------
+
+// Reads input into document.
+func ReadDocument(r io.Reader) (doc *Document, err error) {
+	return readDocument(r, newBudget(context.Background(), nil))
+}
+
+// Reads input into document within budget.
+func readDocument(r io.Reader, b *budget) (doc *Document, err error) {
+	lex := newLexer(r, b)
+
+	block := &Block{Kind: BlockRoot}
+	doc = &Document{Blocks: []*Block{block}}
+
+	// Comments waiting for next entry or block
+	var comments []string
+
+	// List being filled
+	var list *Entry
+
+	for {
+		tok, err := lex.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok.typ == tokEOF {
+			break
+		}
+
+		// Same as reader, 'k += v' sticks lines together
+		if tok.plus {
+			if err = appendLines(lex, tok, b); err != nil {
+				return nil, errorAt("", tok, err)
+			}
+		}
+		next, err := lex.peek()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok.typ {
+
+		case tokComment:
+			text := tok.raw
+			if tok.plus && tok.value != "" {
+				text += " " + tok.value
+			}
+			comments = append(comments, text)
+			continue
+
+		case tokSection, tokMap:
+			block = &Block{Kind: BlockSection, Name: tok.name, Line: tok.line, Col: tok.col, Comments: comments}
+			if tok.typ == tokMap {
+				block.Kind, block.Key = BlockMap, tok.value
+			}
+			doc.Blocks = append(doc.Blocks, block)
+			list = nil
+
+		case tokInclude:
+			block.Entries = append(block.Entries, &Entry{Kind: EntryInclude, Value: tok.value, Line: tok.line, Col: tok.col, Comments: comments})
+			list = nil
+
+		case tokKeyValue:
+			e := &Entry{Kind: EntryValue, Key: tok.name, Value: tok.value, Append: tok.plus, Line: tok.line, Col: tok.col, Comments: comments}
+			list = nil
+			if tok.value == "" && isValue(next) {
+				e.Kind, list = EntryList, e
+			}
+			block.Entries = append(block.Entries, e)
+
+		case tokValue:
+			if list == nil {
+				return nil, errorAt("", tok, fmt.Errorf("error, value without key: %s", tok.raw))
+			}
+			list.Items = append(list.Items, tok.value)
+			// Comments within list stay with next entry
+			continue
+		}
+		comments = nil
+	}
+
+	doc.Comments = comments
+	return
+}
+
+//------------------------------------------------------------
+// Writing
+//------------------------------------------------------------
+
+// Writes document as input. Entries of sections and maps
+// are indented, list items are indented once more.
+func (doc *Document) WriteTo(w io.Writer) (n int64, err error) {
+	m, err := io.WriteString(w, doc.String())
+	return int64(m), err
+}
+
+// Returns document as input.
+func (doc *Document) String() string {
+	var sb strings.Builder
+	for _, b := range doc.Blocks {
+		indent := ""
+		if b.Kind != BlockRoot {
+			if sb.Len() > 0 {
+				sb.WriteByte('\n')
+			}
+			writeComments(&sb, "", b.Comments)
+			sb.WriteString(b.Header())
+			sb.WriteByte('\n')
+			indent = "    "
+		}
+
+		for _, e := range b.Entries {
+			writeComments(&sb, indent, e.Comments)
+			sb.WriteString(indent)
+			sb.WriteString(e.String())
+			sb.WriteByte('\n')
+			for _, item := range e.Items {
+				sb.WriteString(indent + "    " + item + "\n")
+			}
+		}
+	}
+	writeComments(&sb, "", doc.Comments)
+	return sb.String()
+}
+
+// Returns header of block, empty for root.
+func (b *Block) Header() string {
+	switch {
+	case b.Kind == BlockSection:
+		return "[" + b.Name + "]"
+	case b.Kind == BlockMap && b.Key != "":
+		return "[map." + b.Name + " | " + b.Key + "]"
+	case b.Kind == BlockMap:
+		return "[map." + b.Name + "]"
+	}
+	return ""
+}
+
+// Returns first line of entry.
+func (e *Entry) String() string {
+	switch {
+	case e.Kind == EntryInclude && strings.ContainsAny(e.Value, " \t\""):
+		return `@include "` + e.Value + `"`
+	case e.Kind == EntryInclude:
+		return "@include " + e.Value
+	case e.Kind == EntryList || e.Value == "":
+		return e.Key + " ="
+	case e.Append:
+		return e.Key + " += " + e.Value
+	}
+	return e.Key + " = " + e.Value
+}
+
+func writeComments(sb *strings.Builder, indent string, comments []string) {
+	for _, c := range comments {
+		sb.WriteString(indent + c + "\n")
+	}
+}
@@ -0,0 +1,184 @@
+package skini
+
+/*
+Encoding -- detects input encoding by byte order mark,
+decodes it into UTF-8 and normalizes line endings.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------
+// Input decoding
+//------------------------------------------------------------
+
+// Input is not valid UTF-8
+var ErrInvalidUTF8 = errors.New("error, invalid UTF-8")
+
+// Byte order marks
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+)
+
+// Wraps reader so that it yields UTF-8 with LF line endings.
+// Byte order mark is stripped. UTF-16 input is decoded
+// when byte order mark says so.
+func decodeInput(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(bomUTF8))
+
+	var src io.Reader = br
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		br.Discard(len(bomUTF8))
+	case bytes.HasPrefix(head, bomUTF16BE):
+		br.Discard(len(bomUTF16BE))
+		src = &utf16Reader{r: br, bigEndian: true}
+	case bytes.HasPrefix(head, bomUTF16LE):
+		br.Discard(len(bomUTF16LE))
+		src = &utf16Reader{r: br}
+	}
+	return &newlineReader{r: src}
+}
+
+// Finds column of first byte that is not valid UTF-8.
+// Returns 0 if line is valid.
+func invalidUTF8Col(line []byte) int {
+	if utf8.Valid(line) {
+		return 0
+	}
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRune(line[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i + 1
+		}
+		i += size
+	}
+	return 0
+}
+
+//------------------------------------------------------------
+// Line endings
+//------------------------------------------------------------
+
+// Reader translating CRLF and lone CR line endings into LF.
+type newlineReader struct {
+	r io.Reader
+
+	// Previous read ended with CR
+	cr bool
+}
+
+func (nr *newlineReader) Read(p []byte) (n int, err error) {
+	for {
+		m, err := nr.r.Read(p)
+
+		// Translate in place, output never outgrows input
+		for i := 0; i < m; i++ {
+			c := p[i]
+			if nr.cr {
+				nr.cr = false
+				if c == '\n' {
+					// Second half of CRLF
+					continue
+				}
+			}
+			if c == '\r' {
+				nr.cr, c = true, '\n'
+			}
+			p[n] = c
+			n++
+		}
+
+		if n > 0 || err != nil || m == 0 {
+			return n, err
+		}
+	}
+}
+
+//------------------------------------------------------------
+// UTF-16
+//------------------------------------------------------------
+
+// Reader decoding UTF-16 into UTF-8.
+type utf16Reader struct {
+	r         *bufio.Reader
+	bigEndian bool
+
+	// Decoded but not yet returned bytes
+	out []byte
+
+	// Code unit read ahead while looking for surrogate pair
+	pending    uint16
+	hasPending bool
+}
+
+func (ur *utf16Reader) Read(p []byte) (n int, err error) {
+	for len(ur.out) < len(p) {
+		r, err := ur.readRune()
+		if err != nil {
+			if len(ur.out) > 0 {
+				break
+			}
+			return 0, err
+		}
+		ur.out = utf8.AppendRune(ur.out, r)
+	}
+	n = copy(p, ur.out)
+	ur.out = ur.out[n:]
+	return
+}
+
+// Reads one code point, combining surrogate pairs.
+// Broken surrogates decode as replacement character.
+func (ur *utf16Reader) readRune() (r rune, err error) {
+	u, err := ur.readUnit()
+	if err != nil {
+		return
+	}
+	if !utf16.IsSurrogate(rune(u)) {
+		return rune(u), nil
+	}
+
+	u2, err := ur.readUnit()
+	if err == io.EOF {
+		return utf8.RuneError, nil
+	}
+	if err != nil {
+		return
+	}
+	if r = utf16.DecodeRune(rune(u), rune(u2)); r == utf8.RuneError {
+		// Not a pair, second unit stands on its own
+		ur.pending, ur.hasPending = u2, true
+	}
+	return r, nil
+}
+
+// Reads one 16 bit code unit.
+func (ur *utf16Reader) readUnit() (u uint16, err error) {
+	if ur.hasPending {
+		ur.hasPending = false
+		return ur.pending, nil
+	}
+
+	var b [2]byte
+	if _, err = io.ReadFull(ur.r, b[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// Odd trailing byte
+			return utf8.RuneError, nil
+		}
+		return
+	}
+	if ur.bigEndian {
+		return uint16(b[0])<<8 | uint16(b[1]), nil
+	}
+	return uint16(b[1])<<8 | uint16(b[0]), nil
+}
@@ -0,0 +1,89 @@
+package skini
+
+/*
+Errors -- errors reported while reading input.
+*/
+
+import (
+	"errors"
+	"fmt"
+)
+
+//------------------------------------------------------------
+// Errors
+//------------------------------------------------------------
+
+// Parse error with position of the line that caused it.
+type ParseError struct {
+	// File name, empty if input is not a file
+	File string
+
+	// Line and column, both from 1
+	Line int
+	Col  int
+
+	// Underlying error
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 && e.File != "" {
+		return fmt.Sprintf("%s: %s", e.File, e.Err)
+	}
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	if e.File != "" {
+		return fmt.Sprintf("%s:%v: %s", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %v: %s", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Attaches file and position of token to error
+// unless already positioned.
+func errorAt(file string, tok *token, err error) error {
+	if err == nil {
+		return nil
+	}
+	var perr *ParseError
+	if errors.As(err, &perr) {
+		if perr.File == "" {
+			perr.File = file
+		}
+		return err
+	}
+	if tok == nil {
+		return &ParseError{File: file, Err: err}
+	}
+	return &ParseError{File: file, Line: tok.line, Col: tok.col, Err: err}
+}
+
+//------------------------------------------------------------
+// Unknown keys
+//------------------------------------------------------------
+
+// Input key, section or map has no matching struct field.
+// Use errors.Is to tell it from other errors.
+var ErrUnknownKey = errors.New("error, unknown key")
+
+// Error of key without field, keeps its own message
+type unknownKeyError struct {
+	msg string
+}
+
+func (e *unknownKeyError) Error() string {
+	return e.msg
+}
+
+func (e *unknownKeyError) Is(target error) bool {
+	return target == ErrUnknownKey
+}
+
+// Creates error of key without field.
+func unknownKey(format string, args ...interface{}) error {
+	return &unknownKeyError{fmt.Sprintf(format, args...)}
+}
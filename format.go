@@ -0,0 +1,236 @@
+package skini
+
+/*
+Format -- canonical layout of input:
+  key = value               root keys unindented
+  list =
+      item                  items indented once more
+                            blank line before every header
+  [section]
+      key   = value         keys of sections and maps indented,
+      other = value         = optionally aligned
+      text += long text     += text on one line or wrapped
+          wrapped text
+
+Comments are kept above the entry or header they precede.
+Formatting formatted input changes nothing.
+*/
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+)
+
+// Style of formatted output. Zero value gives default style.
+type FormatStyle struct {
+	// Spaces before keys of sections and maps, 4 if zero
+	Indent int
+
+	// Spaces before list items relative to their key, 4 if zero
+	ItemIndent int
+
+	// Pads keys of block so that = line up
+	AlignEquals bool
+
+	// Wraps += text at width, zero writes it on one line
+	Width int
+
+	// Sorts keys of block. Includes stay in place and keys
+	// are not moved across them.
+	SortKeys bool
+}
+
+// Formats input in canonical layout of style, nil for default.
+func Format(src []byte, style *FormatStyle) ([]byte, error) {
+	if style == nil {
+		style = &FormatStyle{}
+	}
+	doc, err := readDocument(bytes.NewReader(src), newBudget(context.Background(), nil))
+	if err != nil {
+		return nil, err
+	}
+
+	f := &formatter{style: *style}
+	if f.style.Indent == 0 {
+		f.style.Indent = 4
+	}
+	if f.style.ItemIndent == 0 {
+		f.style.ItemIndent = 4
+	}
+	for _, b := range doc.Blocks {
+		f.block(b)
+	}
+	f.comments("", doc.Comments)
+	return f.buf.Bytes(), nil
+}
+
+//------------------------------------------------------------
+// Formatter
+//------------------------------------------------------------
+
+type formatter struct {
+	style FormatStyle
+	buf   bytes.Buffer
+}
+
+// Writes header of block followed by its entries.
+func (f *formatter) block(b *Block) {
+	indent := ""
+	if b.Kind != BlockRoot {
+		if f.buf.Len() > 0 {
+			f.buf.WriteByte('\n')
+		}
+		f.comments("", b.Comments)
+		f.buf.WriteString(b.Header() + "\n")
+		indent = strings.Repeat(" ", f.style.Indent)
+	}
+
+	entries := b.Entries
+	if f.style.SortKeys {
+		entries = sortEntries(entries)
+	}
+
+	// Width of keys padded so that = of '=' and '+=' line up
+	width := 0
+	if f.style.AlignEquals {
+		for _, e := range entries {
+			if n := len(e.Key) + plusWidth(e); e.Kind != EntryInclude && n > width {
+				width = n
+			}
+		}
+	}
+
+	for _, e := range entries {
+		f.comments(indent, e.Comments)
+		if e.Kind == EntryInclude {
+			f.buf.WriteString(indent + e.String() + "\n")
+			continue
+		}
+
+		key := e.Key
+		if pad := width - len(key) - plusWidth(e); pad > 0 {
+			key += strings.Repeat(" ", pad)
+		}
+		switch {
+		case e.Kind == EntryList || e.Value == "":
+			f.line(indent, key+" =")
+		case e.Append:
+			f.appendText(indent, key, e.Value)
+		default:
+			f.line(indent, key+" = "+e.Value)
+		}
+
+		items := indent + strings.Repeat(" ", f.style.ItemIndent)
+		for _, item := range e.Items {
+			f.buf.WriteString(items + item + "\n")
+		}
+	}
+}
+
+// Extra width taken by + of += entry.
+func plusWidth(e *Entry) int {
+	if e.Append && e.Value != "" {
+		return 1
+	}
+	return 0
+}
+
+// Writes line trimming padding of empty value.
+func (f *formatter) line(indent, s string) {
+	f.buf.WriteString(indent + strings.TrimRight(s, " ") + "\n")
+}
+
+// Writes comments as written.
+func (f *formatter) comments(indent string, comments []string) {
+	for _, c := range comments {
+		f.buf.WriteString(indent + c + "\n")
+	}
+}
+
+// Writes key += text, wrapped at width if style says so.
+// Lines are joined back with single space, so text breaks
+// only at single spaces and never before words that would
+// read as anything but text.
+func (f *formatter) appendText(indent, key, text string) {
+	first := indent + key + " += "
+	if f.style.Width <= 0 {
+		f.line("", first+text)
+		return
+	}
+
+	// Greedy wrap at single spaces
+	var lines []string
+	line, limit := "", f.style.Width-len(first)
+	for _, word := range splitWords(text) {
+		if line != "" && len(line)+1+len(word) > limit {
+			lines = append(lines, line)
+			line, limit = "", f.style.Width-len(indent)
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	lines = append(lines, line)
+
+	// Lines that would not read as text stay on line above
+	for i := 1; i < len(lines); {
+		if isContinuation(lines[i]) {
+			i++
+			continue
+		}
+		lines[i-1] += " " + lines[i]
+		lines = append(lines[:i], lines[i+1:]...)
+	}
+
+	f.line("", first+lines[0])
+	for _, line := range lines[1:] {
+		f.line(indent, line)
+	}
+}
+
+// Splits text at single spaces. Runs of spaces stay within words.
+func splitWords(text string) (words []string) {
+	start := 0
+	for i := 1; i < len(text)-1; i++ {
+		if text[i] == ' ' && text[i-1] != ' ' && text[i+1] != ' ' {
+			words = append(words, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(words, text[start:])
+}
+
+// Is line read as continuation of += text ?
+func isContinuation(line string) bool {
+	if line == "" || line != strings.TrimSpace(line) {
+		return false
+	}
+	var tok token
+	classify(&tok, line)
+	switch tok.typ {
+	case tokValue:
+		return !tok.likeHeader
+	case tokComment:
+		return !tok.likeKeyValue
+	}
+	return false
+}
+
+// Sorts entries by key between includes. Equal keys keep
+// their order, so later still wins.
+func sortEntries(entries []*Entry) []*Entry {
+	sorted := append([]*Entry{}, entries...)
+	start := 0
+	for i := 0; i <= len(sorted); i++ {
+		if i < len(sorted) && sorted[i].Kind != EntryInclude {
+			continue
+		}
+		run := sorted[start:i]
+		sort.SliceStable(run, func(a, b int) bool { return run[a].Key < run[b].Key })
+		start = i + 1
+	}
+	return sorted
+}
@@ -0,0 +1,68 @@
+package skini
+
+/*
+FS -- parsing files of io/fs file systems: embed.FS,
+fstest.MapFS, zip archives and others. Names are
+slash separated as in fs.FS, includes are read from
+the same file system.
+*/
+
+import (
+	"context"
+	"io/fs"
+	"os"
+)
+
+// Parses named file of file system.
+func ParseFileFS(target interface{}, fsys fs.FS, filename string) (err error) {
+	return ParseFileFSWith(target, fsys, filename, nil)
+}
+
+// Parses named file of file system using given options.
+func ParseFileFSWith(target interface{}, fsys fs.FS, filename string, opts *Options) (err error) {
+	return ParseFileFSContext(context.Background(), target, fsys, filename, opts)
+}
+
+// Parses named file of file system using given options
+// and context.
+func ParseFileFSContext(ctx context.Context, target interface{}, fsys fs.FS, filename string, opts *Options) (err error) {
+	return parseFile(ctx, target, fsys, filename, opts)
+}
+
+// Reads single field specified by key from named file of file system.
+func SeekFileFS(target interface{}, fsys fs.FS, filename string, key string) (value string, err error) {
+	return seekFile(target, fsys, filename, key)
+}
+
+// Finds first relevant config file in directory of file system.
+// Arguments are as of ParseDir.
+func ParseDirFS(target interface{}, fsys fs.FS, dir string, pattern string, idkey string, matcher func(string) bool) (err error) {
+	return ParseDirFSWith(target, fsys, dir, pattern, idkey, matcher, nil)
+}
+
+// Finds first relevant config file in directory of file system
+// and parses it using given options.
+func ParseDirFSWith(target interface{}, fsys fs.FS, dir string, pattern string, idkey string, matcher func(string) bool, opts *Options) (err error) {
+	return parseDir(target, fsys, dir, pattern, idkey, matcher, opts)
+}
+
+//------------------------------------------------------------
+// File system access
+//------------------------------------------------------------
+
+// Opens named file of file system, of OS if nil.
+func openFile(fsys fs.FS, name string) (fs.File, error) {
+	if fsys == nil {
+		return os.Open(name)
+	}
+	return fsys.Open(name)
+}
+
+// Reads directory of file system, of OS if nil.
+// Entries are sorted by name.
+func readDir(fsys fs.FS, dir string) ([]fs.DirEntry, error) {
+	if fsys == nil {
+		return os.ReadDir(dir)
+	}
+	return fs.ReadDir(fsys, dir)
+}
@@ -0,0 +1,172 @@
+package skini
+
+/*
+JSON -- converts documents to and from JSON.
+Comments and includes have no place in JSON.
+Numbers and booleans read from JSON become strings
+written exactly as in JSON.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//------------------------------------------------------------
+// Export
+//------------------------------------------------------------
+
+// Converts document into JSON object. Returns *ConvertError
+// along with output if anything could not be represented.
+func ToJSON(doc *Document) ([]byte, error) {
+	ls := &losses{format: "JSON"}
+	t := docToTree(doc, formatCaps{name: "JSON", nulls: true}, ls)
+
+	var buf bytes.Buffer
+	writeJSON(&buf, t, "")
+	buf.WriteByte('\n')
+	return buf.Bytes(), ls.err()
+}
+
+// Writes value indented by two spaces per level.
+func writeJSON(buf *bytes.Buffer, v interface{}, indent string) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case string:
+		buf.WriteString(jsonQuote(v))
+	case []string:
+		buf.WriteString("[")
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(jsonQuote(item))
+		}
+		buf.WriteString("]")
+	case *tree:
+		if len(v.keys) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteString("{\n")
+		for i, key := range v.keys {
+			buf.WriteString(indent + "  " + jsonQuote(key) + ": ")
+			writeJSON(buf, v.values[key], indent+"  ")
+			if i < len(v.keys)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "}")
+	}
+}
+
+// Quotes string as JSON string. Result is also
+// a valid TOML basic string and YAML double quoted scalar.
+func jsonQuote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+//------------------------------------------------------------
+// Import
+//------------------------------------------------------------
+
+// Converts JSON object into document. Returns *ConvertError
+// along with document if anything could not be represented.
+func FromJSON(data []byte) (doc *Document, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	v, err := readJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := v.(*tree)
+	if !ok {
+		return nil, fmt.Errorf("error, JSON must be an object")
+	}
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("error, unexpected data after JSON object")
+	}
+
+	ls := &losses{format: "INI"}
+	return treeToDoc(root, ls), ls.err()
+}
+
+// Reads JSON value keeping order of object keys.
+func readJSON(dec *json.Decoder) (v interface{}, err error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			return readJSONObject(dec)
+		}
+		return readJSONArray(dec)
+	case string:
+		return tok, nil
+	case json.Number:
+		return tok.String(), nil
+	case bool:
+		return fmt.Sprint(tok), nil
+	}
+	return nil, nil
+}
+
+func readJSONObject(dec *json.Decoder) (v interface{}, err error) {
+	t := newTree()
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+		if v, err = readJSON(dec); err != nil {
+			return nil, err
+		}
+		t.set(key, v, 0)
+	}
+	_, err = dec.Token()
+	return t, err
+}
+
+// Arrays of strings convert into lists. Arrays holding
+// anything else are read but cannot be converted.
+func readJSONArray(dec *json.Decoder) (v interface{}, err error) {
+	items := []string{}
+	var what lost
+	for dec.More() {
+		item, err := readJSON(dec)
+		if err != nil {
+			return nil, err
+		}
+		switch item := item.(type) {
+		case string:
+			items = append(items, item)
+		case nil:
+			what = "array with null"
+		case *tree:
+			what = "array of objects"
+		default:
+			what = "array of arrays"
+		}
+	}
+	if _, err = dec.Token(); err != nil {
+		return
+	}
+	if what != "" {
+		return what, nil
+	}
+	return items, nil
+}
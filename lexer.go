@@ -0,0 +1,431 @@
+package skini
+
+/*
+Lexer -- reads decoded input line by line and classifies
+each line exactly once into a typed token.
+Lexer knows nothing about targets, it only tells
+what every line looks like.
+*/
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+//------------------------------------------------------------
+// Tokens
+//------------------------------------------------------------
+
+// Token types
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+
+	// # comment, ; comment
+	tokComment
+
+	// [section]
+	tokSection
+
+	// [map.name] or [map.name | key]
+	tokMap
+
+	// key = value, key += value
+	tokKeyValue
+
+	// Anything else: list item or appended text
+	tokValue
+
+	// @include path
+	tokInclude
+)
+
+// Token is a single classified input line.
+type token struct {
+	typ tokenType
+
+	// Position: line number and column of
+	// first non blank character, both from 1
+	line int
+	col  int
+
+	// Line with surrounding spaces and tabs trimmed
+	raw string
+
+	// Section name, map name or key
+	name string
+
+	// Map key, value of key
+	value string
+
+	// Key uses += instead of =. Also set
+	// for comments that look like it.
+	plus bool
+
+	// Line looks like key value pair. Set for
+	// key values and for comments that look like them.
+	likeKeyValue bool
+
+	// Line looks like [section] or [map.name]
+	// even if it couldn't be parsed as such
+	likeHeader bool
+}
+
+//------------------------------------------------------------
+// Lexer
+//------------------------------------------------------------
+
+// Lexer of input
+type lexer struct {
+	reader *bufio.Reader
+	line   int
+
+	// Limits and consumed resources
+	budget *budget
+
+	// Syntax of input
+	dialect Dialect
+
+	// Buffer for lines longer than reader's buffer
+	long []byte
+
+	// Tokens are reused: one handed out by next,
+	// other one read ahead by peek
+	slots  [2]token
+	free   int
+	peeked *token
+}
+
+// Creates lexer reading from given reader within budget.
+func newLexer(r io.Reader, b *budget) *lexer {
+	return &lexer{reader: bufio.NewReader(decodeInput(b.reader(r))), budget: b, dialect: b.opts.Dialect}
+}
+
+// Returns next token without consuming it.
+func (lx *lexer) peek() (tok *token, err error) {
+	if lx.peeked == nil {
+		tok = &lx.slots[lx.free]
+		if err = lx.read(tok); err != nil {
+			return nil, err
+		}
+		lx.peeked = tok
+	}
+	return lx.peeked, nil
+}
+
+// Returns and consumes next token.
+// Token stays valid until the following call to next.
+// Blank lines are never returned.
+// At the end of input returns EOF token.
+func (lx *lexer) next() (tok *token, err error) {
+	if tok, err = lx.peek(); err != nil {
+		return
+	}
+	if tok.typ != tokEOF {
+		lx.peeked = nil
+		lx.free = 1 - lx.free
+	}
+	return
+}
+
+// Drops peeked token. Unlike next, keeps token
+// handed out by next valid.
+func (lx *lexer) skip() {
+	if lx.peeked != nil && lx.peeked.typ != tokEOF {
+		lx.peeked = nil
+	}
+}
+
+// Reads next not blank line and classifies it into token.
+func (lx *lexer) read(tok *token) (err error) {
+	for {
+		text, err := lx.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if col := invalidUTF8Col(text); col > 0 {
+			return lx.errorf(col, ErrInvalidUTF8)
+		}
+
+		// Trim spaces and tabs
+		start, end := 0, len(text)
+		for start < end && (text[start] == ' ' || text[start] == '\t') {
+			start++
+		}
+		for end > start && (text[end-1] == ' ' || text[end-1] == '\t') {
+			end--
+		}
+		if start == end {
+			continue
+		}
+		line, first := string(text[start:end]), lx.line
+
+		// Git config lines continue after backslash
+		if lx.dialect == DialectGitConfig {
+			if line, err = lx.continueLine(line); err != nil {
+				return err
+			}
+		}
+
+		if err = lx.dialect.classify(tok, line); err != nil {
+			return &ParseError{Line: first, Col: start + 1, Err: err}
+		}
+		tok.line = first
+		tok.col = start + 1
+		return nil
+	}
+	*tok = token{typ: tokEOF, line: lx.line + 1, col: 1}
+	return
+}
+
+// Reads next line of any length without end of line marker.
+// Line is valid until the following call. Returns io.EOF
+// when there are no more lines.
+func (lx *lexer) readLine() (line []byte, err error) {
+	line, err = lx.reader.ReadSlice('\n')
+
+	maxLine := lx.budget.opts.MaxLineLength
+
+	// Line doesn't fit into reader's buffer, collect it in parts
+	if err == bufio.ErrBufferFull {
+		lx.long = append(lx.long[:0], line...)
+		for err == bufio.ErrBufferFull {
+			if maxLine > 0 && len(lx.long) > maxLine {
+				break
+			}
+			line, err = lx.reader.ReadSlice('\n')
+			lx.long = append(lx.long, line...)
+		}
+		line = lx.long
+	}
+
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err != nil && err != bufio.ErrBufferFull {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, &ParseError{Line: lx.line + 1, Col: 1, Err: err}
+	}
+	lx.line++
+	if err = lx.budget.line(); err != nil {
+		return nil, lx.errorf(1, err)
+	}
+
+	// Drop end of line marker, CR and CRLF
+	// are already translated into LF
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+
+	if maxLine > 0 && len(line) > maxLine {
+		return nil, lx.errorf(maxLine+1, &LimitError{ErrLineTooLong, int64(maxLine)})
+	}
+	return line, nil
+}
+
+// Joins lines ending with backslash with lines that follow.
+func (lx *lexer) continueLine(line string) (string, error) {
+	for gitContinues(line) {
+		text, err := lx.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if col := invalidUTF8Col(text); col > 0 {
+			return "", lx.errorf(col, ErrInvalidUTF8)
+		}
+		line = line[:len(line)-1] + strings.TrimRight(string(text), " \t")
+		if err = lx.budget.expansion(len(line)); err != nil {
+			return "", lx.errorf(1, err)
+		}
+	}
+	return line, nil
+}
+
+// Positions error at current line and given column.
+func (lx *lexer) errorf(col int, err error) error {
+	return &ParseError{Line: lx.line, Col: col, Err: err}
+}
+
+//------------------------------------------------------------
+// Line classification
+//------------------------------------------------------------
+
+// Classifies trimmed not empty line into token.
+func classify(tok *token, line string) {
+	*tok = token{raw: line}
+
+	// Comment ?
+	if line[0] == '#' || line[0] == ';' {
+		tok.typ = tokComment
+		_, _, tok.plus, tok.likeKeyValue = splitKeyValue(line)
+		return
+	}
+
+	// Include ?
+	if path, ok := splitInclude(line); ok {
+		tok.typ, tok.value = tokInclude, path
+		return
+	}
+
+	// Header ?
+	if line[0] == '[' && line[len(line)-1] == ']' {
+		inner := line[1 : len(line)-1]
+
+		// Map ? Must check before section
+		if name, key, ok := splitMap(inner); ok {
+			tok.typ, tok.name, tok.value = tokMap, name, key
+			return
+		}
+
+		// Section ?
+		if name, ok := splitSection(inner); ok {
+			tok.typ, tok.name = tokSection, name
+			return
+		}
+
+		tok.likeHeader = isLikeMap(inner) || isLikeSection(inner)
+	}
+
+	// Key value ?
+	if key, value, plus, ok := splitKeyValue(line); ok {
+		tok.typ, tok.name, tok.value, tok.plus = tokKeyValue, key, value, plus
+		tok.likeKeyValue = true
+		return
+	}
+
+	// Value
+	tok.typ, tok.value = tokValue, line
+}
+
+// Is whitespace as in regex \s ?
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// Is ASCII letter or digit ?
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// Skips whitespace from position i.
+func skipSpace(s string, i int) int {
+	for i < len(s) && isSpace(s[i]) {
+		i++
+	}
+	return i
+}
+
+// Splits map header contents: map.name | key
+// Name must be present, key is optional.
+func splitMap(inner string) (name, key string, ok bool) {
+	i := skipSpace(inner, 0)
+	if !strings.HasPrefix(inner[i:], "map.") {
+		return
+	}
+	i += len("map.")
+
+	// Name
+	start := i
+	for i < len(inner) && (isAlnum(inner[i]) || inner[i] == '_' || inner[i] == '.') {
+		i++
+	}
+	if i == start {
+		return
+	}
+	name = inner[start:i]
+
+	// Optional | key
+	i = skipSpace(inner, i)
+	if i < len(inner) && inner[i] == '|' {
+		i = skipSpace(inner, i+1)
+		start = i
+		for i < len(inner) && (isAlnum(inner[i]) || strings.IndexByte("_-.*", inner[i]) >= 0) {
+			i++
+		}
+		key = inner[start:i]
+		i = skipSpace(inner, i)
+	}
+
+	if i != len(inner) {
+		return "", "", false
+	}
+	return name, key, true
+}
+
+// Splits section header contents: name
+func splitSection(inner string) (name string, ok bool) {
+	i := skipSpace(inner, 0)
+	start := i
+	for i < len(inner) && (isAlnum(inner[i]) || inner[i] == '.') {
+		i++
+	}
+	if i == start || skipSpace(inner, i) != len(inner) {
+		return
+	}
+	return inner[start:i], true
+}
+
+// Splits key [+]= value. Key is everything up to the first '='
+// less the whitespace that must precede '=' or '+='.
+// Keys may be padded to align =.
+func splitKeyValue(line string) (key, value string, plus, ok bool) {
+	eq := strings.IndexByte(line, '=')
+	if eq < 2 {
+		return
+	}
+
+	sp := eq - 1
+	if line[sp] == '+' {
+		plus = true
+		sp--
+	}
+	if sp < 1 || !isSpace(line[sp]) {
+		return "", "", false, false
+	}
+
+	return strings.TrimRight(line[:sp], " \t"), line[skipSpace(line, eq+1):], plus, true
+}
+
+// Splits include directive: @include path
+// Path can be quoted with double quotes.
+func splitInclude(line string) (path string, ok bool) {
+	const directive = "@include"
+	if !strings.HasPrefix(line, directive) || len(line) == len(directive) || !isSpace(line[len(directive)]) {
+		return
+	}
+	path = line[skipSpace(line, len(directive)):]
+	if len(path) >= 2 && path[0] == '"' && path[len(path)-1] == '"' {
+		path = path[1 : len(path)-1]
+	}
+	return path, path != ""
+}
+
+// Is anything looking like a section: letters, digits, dots,
+// bars and whitespace ?
+func isLikeSection(inner string) bool {
+	if inner == "" {
+		return false
+	}
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		if !isAlnum(c) && c != '.' && c != '|' && !isSpace(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Is anything looking like a map: map.* ?
+func isLikeMap(inner string) bool {
+	return strings.HasPrefix(inner[skipSpace(inner, 0):], "map.")
+}
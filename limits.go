@@ -0,0 +1,150 @@
+package skini
+
+/*
+Limits -- resource limits guarding against hostile input.
+Budget is shared by the input and all of its includes.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//------------------------------------------------------------
+// Limit errors
+//------------------------------------------------------------
+
+// Violated limits, wrapped into LimitError
+var (
+	ErrLineTooLong       = errors.New("error, line too long")
+	ErrInputTooLarge     = errors.New("error, input too large")
+	ErrTooManyLines      = errors.New("error, too many lines")
+	ErrListTooLong       = errors.New("error, list too long")
+	ErrMapTooLarge       = errors.New("error, map too large")
+	ErrIncludeTooDeep    = errors.New("error, includes nested too deep")
+	ErrExpansionTooLarge = errors.New("error, expanded value too large")
+)
+
+// Limit error tells which limit was violated and its value.
+// Use errors.Is with Err* values above to tell limits apart.
+type LimitError struct {
+	Err   error
+	Limit int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s, limit is %v", e.Err, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+//------------------------------------------------------------
+// Budget
+//------------------------------------------------------------
+
+// How often context is checked, in lines
+const ctxCheckLines = 256
+
+// Budget tracks resources consumed by input.
+type budget struct {
+	ctx  context.Context
+	opts Options
+
+	// Consumed so far
+	bytes int64
+	lines int64
+}
+
+// Creates budget for given context and options.
+func newBudget(ctx context.Context, opts *Options) *budget {
+	b := &budget{ctx: ctx}
+	if opts != nil {
+		b.opts = *opts
+	}
+	if b.ctx == nil {
+		b.ctx = context.Background()
+	}
+	return b
+}
+
+// Counts read line. Checks line limit and, every
+// now and then, whether context is done.
+func (b *budget) line() error {
+	b.lines++
+	if max := b.opts.MaxLines; max > 0 && b.lines > max {
+		return &LimitError{ErrTooManyLines, max}
+	}
+	if b.lines%ctxCheckLines == 0 {
+		return b.ctx.Err()
+	}
+	return nil
+}
+
+// Checks length of list about to get one more item.
+func (b *budget) listItem(count int) error {
+	if max := b.opts.MaxListLength; max > 0 && int64(count) >= max {
+		return &LimitError{ErrListTooLong, max}
+	}
+	return nil
+}
+
+// Checks size of map about to get one more entry.
+func (b *budget) mapEntry(size int) error {
+	if max := b.opts.MaxMapSize; max > 0 && int64(size) >= max {
+		return &LimitError{ErrMapTooLarge, max}
+	}
+	return nil
+}
+
+// Checks nesting depth of include about to be read.
+func (b *budget) include(depth int) error {
+	if max := b.opts.MaxIncludeDepth; max > 0 && int64(depth) > max {
+		return &LimitError{ErrIncludeTooDeep, max}
+	}
+	return nil
+}
+
+// Checks length of value being expanded.
+func (b *budget) expansion(length int) error {
+	if max := b.opts.MaxExpansion; max > 0 && int64(length) > max {
+		return &LimitError{ErrExpansionTooLarge, max}
+	}
+	return nil
+}
+
+// Wraps reader so that bytes read are counted against
+// total bytes limit and reading stops once context is done.
+func (b *budget) reader(r io.Reader) io.Reader {
+	return &budgetReader{r: r, b: b}
+}
+
+// Reader counting bytes against budget
+type budgetReader struct {
+	r io.Reader
+	b *budget
+}
+
+func (br *budgetReader) Read(p []byte) (n int, err error) {
+	if err = br.b.ctx.Err(); err != nil {
+		return
+	}
+
+	// Never read more than one byte past the limit
+	max := br.b.opts.MaxBytes
+	if max > 0 {
+		if left := max - br.b.bytes + 1; int64(len(p)) > left {
+			p = p[:left]
+		}
+	}
+
+	n, err = br.r.Read(p)
+	br.b.bytes += int64(n)
+	if max > 0 && br.b.bytes > max {
+		return 0, &LimitError{ErrInputTooLarge, max}
+	}
+	return
+}
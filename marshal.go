@@ -0,0 +1,330 @@
+package skini
+
+/*
+Marshal -- writes struct back as improved INI:
+  key = value               scalar field
+  key =                     slice field, one item per line
+      item
+  [section]                 nested struct
+  [map.name]                map of scalars
+  [map.name | key]          map of maps, one block per key
+
+Secret fields are written as ********.
+*/
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Writes struct as input that parses back into the same
+// struct, except for secret fields which are redacted.
+// Values looking like references are escaped with @@,
+// parse with Options.Secrets set to read them back.
+func Marshal(v interface{}) ([]byte, error) {
+	doc, err := marshalDocument(v, &marshaler{})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(doc.String()), nil
+}
+
+// Returns struct as input, with secret fields redacted.
+// Safe to print or log. Returns error text on failure.
+func String(v interface{}) string {
+	data, err := Marshal(v)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// Builds document of struct with marshaler.
+func marshalDocument(v interface{}, m *marshaler) (doc *Document, err error) {
+	elem := reflect.ValueOf(v)
+	for elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Interface {
+		if elem.IsNil() {
+			return nil, fmt.Errorf("error, cannot marshal nil")
+		}
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("error, can only marshal struct, got: %v", elem.Type())
+	}
+
+	root := &Block{Kind: BlockRoot}
+	doc = &Document{Blocks: []*Block{root}}
+	m.doc = doc
+	if err = m.fields(root, elem, false); err != nil {
+		return nil, err
+	}
+	return
+}
+
+//------------------------------------------------------------
+// Marshaler
+//------------------------------------------------------------
+
+// Marshaler appends blocks to document. Root keys must come
+// before any header, so sections and maps wait until root
+// is done.
+type marshaler struct {
+	doc *Document
+
+	// Blocks following root
+	later []*Block
+
+	// Values as they are, neither escaped, redacted nor
+	// checked to read back. Entries of secrets are marked.
+	raw     bool
+	secrets map[*Entry]bool
+}
+
+// Adds fields of struct to block. Nested structs and maps
+// become blocks of their own when block is root.
+func (m *marshaler) fields(b *Block, elem reflect.Value, secret bool) (err error) {
+	if err = m.collect(b, elem, secret); err != nil {
+		return
+	}
+	if b.Kind == BlockRoot {
+		m.doc.Blocks = append(m.doc.Blocks, m.later...)
+	}
+	return
+}
+
+// Collects fields of struct, promoting embedded structs.
+func (m *marshaler) collect(b *Block, elem reflect.Value, secret bool) (err error) {
+	typ := elem.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		field := elem.Field(i)
+
+		if sf.Anonymous && sf.Tag.Get("skini") == "" {
+			if field.Kind() == reflect.Ptr && !field.IsNil() {
+				field = field.Elem()
+			}
+			if field.Kind() == reflect.Struct {
+				if err = m.collect(b, field, secret); err != nil {
+					return
+				}
+			}
+			continue
+		}
+
+		name := fieldInputName(sf)
+		if name == "" {
+			continue
+		}
+		if name == sf.Name {
+			name = lowerFirst(name)
+		}
+		if err = m.field(b, name, field, secret || hasTagOption(sf, "secret")); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Adds single field.
+func (m *marshaler) field(b *Block, name string, field reflect.Value, secret bool) (err error) {
+	for field.Kind() == reflect.Ptr || field.Kind() == reflect.Interface {
+		if field.IsNil() {
+			return
+		}
+		field = field.Elem()
+	}
+
+	switch field.Kind() {
+
+	case reflect.Struct:
+		if b.Kind != BlockRoot {
+			return fmt.Errorf("error, sections cannot nest: %s", name)
+		}
+		if !m.raw && !isValidKey(name) {
+			return fmt.Errorf("error, invalid section name: %s", name)
+		}
+		section := &Block{Kind: BlockSection, Name: name}
+		m.later = append(m.later, section)
+		return m.collect(section, field, secret)
+
+	case reflect.Map:
+		if b.Kind != BlockRoot {
+			return fmt.Errorf("error, maps must be at root: %s", name)
+		}
+		if field.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("error, map keys must be strings: %s", name)
+		}
+		if field.Len() == 0 {
+			return
+		}
+		return m.mapBlocks(name, field, secret)
+
+	case reflect.Slice, reflect.Array:
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		if field.Len() == 0 {
+			return
+		}
+		e := &Entry{Kind: EntryList, Key: name}
+		for i := 0; i < field.Len(); i++ {
+			item, err := m.format(field.Index(i), name, secret)
+			if err != nil {
+				return err
+			}
+			if !m.raw && !isValidItem(item) {
+				return fmt.Errorf("error, list item cannot be written: %s = %s", name, item)
+			}
+			e.Items = append(e.Items, item)
+		}
+		m.add(b, e, secret)
+		return
+	}
+
+	value, err := m.format(field, name, secret)
+	if err != nil {
+		return
+	}
+	if !m.raw && !isValidKey(name) {
+		return fmt.Errorf("error, invalid key: %s", name)
+	}
+	if !m.raw && !isValidValue(value) {
+		return fmt.Errorf("error, value cannot be written: %s = %s", name, value)
+	}
+	m.add(b, &Entry{Kind: EntryValue, Key: name, Value: value}, secret)
+	return
+}
+
+// Adds [map.name] block, or [map.name | key] blocks
+// for map of maps, in key order.
+func (m *marshaler) mapBlocks(name string, field reflect.Value, secret bool) (err error) {
+	keys := sortedMapKeys(field)
+	nested := field.Type().Elem().Kind() == reflect.Map
+
+	block := &Block{Kind: BlockMap, Name: name}
+	if !nested {
+		m.later = append(m.later, block)
+	}
+	for _, key := range keys {
+		value := field.MapIndex(reflect.ValueOf(key).Convert(field.Type().Key()))
+		if !nested {
+			if err = m.mapItem(block, name, key, value, secret); err != nil {
+				return
+			}
+			continue
+		}
+
+		if !m.raw && !isValidKey(key) {
+			return fmt.Errorf("error, invalid map key: %s | %s", name, key)
+		}
+		if value.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("error, map keys must be strings: %s | %s", name, key)
+		}
+		sub := &Block{Kind: BlockMap, Name: name, Key: key}
+		m.later = append(m.later, sub)
+		for _, k := range sortedMapKeys(value) {
+			v := value.MapIndex(reflect.ValueOf(k).Convert(value.Type().Key()))
+			if err = m.mapItem(sub, name, k, v, secret); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Adds key of map block.
+func (m *marshaler) mapItem(b *Block, name, key string, value reflect.Value, secret bool) error {
+	s, err := m.format(value, name+"."+key, secret)
+	if err != nil {
+		return err
+	}
+	if !m.raw && !isValidKey(key) {
+		return fmt.Errorf("error, invalid map key: %s.%s", name, key)
+	}
+	if !m.raw && !isValidValue(s) {
+		return fmt.Errorf("error, value cannot be written: %s.%s = %s", name, key, s)
+	}
+	m.add(b, &Entry{Kind: EntryValue, Key: key, Value: s}, secret)
+	return nil
+}
+
+// Adds entry to block, marking secret of raw output.
+func (m *marshaler) add(b *Block, e *Entry, secret bool) {
+	b.Entries = append(b.Entries, e)
+	if m.raw && secret {
+		m.secrets[e] = true
+	}
+}
+
+// Formats scalar unless output is raw.
+func (m *marshaler) format(field reflect.Value, name string, secret bool) (string, error) {
+	if m.raw {
+		return scalarString(field, name)
+	}
+	return formatField(field, name, secret)
+}
+
+//------------------------------------------------------------
+// Values
+//------------------------------------------------------------
+
+// Formats scalar as read by setValue. Values looking like
+// references are escaped, secrets are redacted.
+func formatField(field reflect.Value, name string, secret bool) (s string, err error) {
+	if s, err = scalarString(field, name); err != nil {
+		return
+	}
+	if isReferenceLike(s) {
+		s = "@" + s
+	}
+	if secret && s != "" {
+		s = Redacted
+	}
+	return
+}
+
+// Formats scalar as it is, nil as empty.
+func scalarString(field reflect.Value, name string) (s string, err error) {
+	for field.Kind() == reflect.Ptr || field.Kind() == reflect.Interface {
+		if field.IsNil() {
+			return "", nil
+		}
+		field = field.Elem()
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		s = field.String()
+	case reflect.Bool:
+		s = strconv.FormatBool(field.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s = strconv.FormatInt(field.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s = strconv.FormatUint(field.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		s = strconv.FormatFloat(field.Float(), 'g', -1, field.Type().Bits())
+	default:
+		return "", fmt.Errorf("error, not yet supported type for field: %s", name)
+	}
+	return
+}
+
+// Gets keys of map with string keys in sorted order.
+func sortedMapKeys(m reflect.Value) []string {
+	keys := make([]string, 0, m.Len())
+	for _, k := range m.MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Lowercases first letter of field name: LogDir --> logDir
+func lowerFirst(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[n:]
+}
@@ -0,0 +1,390 @@
+package skini
+
+/*
+Merge3 -- three-way merge of documents. Changes theirs made
+to base are applied to ours key by key, keeping comments
+and order of ours. Includes are merged as keys that are
+either present or not, list items as sets.
+
+Key changed differently by both sides is a conflict. Ours
+is kept and theirs is written above it as comment:
+
+  # <<<<<<< theirs
+  # port = 8080
+  # >>>>>>> ours
+  port = 9090
+
+Conflicting key removed by one side reads (removed).
+Text written with += that comments end up following is
+written with = so they don't join it.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Markers of conflict comments
+const (
+	conflictTheirs  = "# <<<<<<< theirs"
+	conflictOurs    = "# >>>>>>> ours"
+	conflictRemoved = "# (removed)"
+)
+
+// Key both sides changed. Entries are nil where key is absent.
+type Conflict struct {
+	Path string
+
+	Base   *Entry
+	Ours   *Entry
+	Theirs *Entry
+}
+
+func (c *Conflict) String() string {
+	return fmt.Sprintf("conflict at %s: ours %s, theirs %s", c.Path, showEntry(c.Ours), showEntry(c.Theirs))
+}
+
+//------------------------------------------------------------
+// Merge
+//------------------------------------------------------------
+
+// Merges changes between base and theirs into copy of ours.
+// Returns merged document, with conflicts marked, and
+// conflicts in order of ours, then theirs.
+func Merge3(base, ours, theirs *Document) (*Document, []*Conflict) {
+	m := &merger{
+		doc:    copyDocument(ours),
+		base:   indexDocument(base),
+		theirs: indexDocument(theirs),
+	}
+	m.ours = indexDocument(m.doc)
+
+	for _, path := range m.ours.paths {
+		m.mergeOurs(path)
+	}
+	for _, path := range m.theirs.paths {
+		if m.ours.entries[path] == nil {
+			m.mergeTheirs(path)
+		}
+	}
+	m.dropBlocks()
+	m.settle()
+	return m.doc, m.conflicts
+}
+
+type merger struct {
+	doc *Document
+
+	base   *docIndex
+	ours   *docIndex
+	theirs *docIndex
+
+	conflicts []*Conflict
+}
+
+// Merges key ours has.
+func (m *merger) mergeOurs(path string) {
+	b, o, t := m.base.entries[path], m.ours.entries[path], m.theirs.entries[path]
+	switch {
+	case sameEntry(o, t) || sameEntry(b, t):
+	case sameEntry(b, o) && t == nil:
+		// Repeated sections may each have key
+		for _, block := range m.doc.Blocks {
+			entries := block.Entries[:0]
+			for _, e := range block.Entries {
+				if entryPath(block, e) != path {
+					entries = append(entries, e)
+				}
+			}
+			block.Entries = entries
+		}
+	case sameEntry(b, o):
+		o.Kind, o.Value, o.Items, o.Append = t.Kind, t.Value, copyStrings(t.Items), t.Append
+	case o.Kind == EntryList && t != nil && t.Kind == EntryList && (b == nil || b.Kind == EntryList):
+		var items []string
+		if b != nil {
+			items = b.Items
+		}
+		o.Items = mergeItems(items, o.Items, t.Items)
+	default:
+		m.conflict(path, b, o, t)
+		o.Comments = append(o.Comments, conflictComments(t)...)
+	}
+}
+
+// Merges key only theirs has.
+func (m *merger) mergeTheirs(path string) {
+	b, t := m.base.entries[path], m.theirs.entries[path]
+	switch {
+	case b == nil:
+		m.insert(m.block(m.theirs.blocks[path]), copyEntry(t), path)
+	case !sameEntry(b, t):
+		m.conflict(path, b, nil, t)
+		lines := append(conflictComments(t), conflictRemoved)
+		m.trail(m.theirs.blocks[path], lines)
+	}
+}
+
+func (m *merger) conflict(path string, b, o, t *Entry) {
+	m.conflicts = append(m.conflicts, &Conflict{Path: path, Base: b, Ours: o, Theirs: t})
+}
+
+// Gets block of merged document like block of theirs,
+// adding it after block that precedes it in theirs.
+func (m *merger) block(like *Block) *Block {
+	header := like.Header()
+	for _, b := range m.doc.Blocks {
+		if b.Header() == header {
+			return b
+		}
+	}
+
+	block := &Block{Kind: like.Kind, Name: like.Name, Key: like.Key, Comments: copyStrings(like.Comments)}
+	at := len(m.doc.Blocks)
+	if prev := m.theirs.previous(like); prev != nil {
+		for i, b := range m.doc.Blocks {
+			if b.Header() == prev.Header() {
+				at = i + 1
+			}
+		}
+	}
+	m.doc.Blocks = append(m.doc.Blocks[:at], append([]*Block{block}, m.doc.Blocks[at:]...)...)
+	return block
+}
+
+// Inserts entry after one that precedes it in theirs,
+// at end of block if that is not in ours.
+func (m *merger) insert(block *Block, e *Entry, path string) {
+	at := 0
+	if prev := m.theirs.before[path]; prev != "" {
+		at = len(block.Entries)
+		for i, o := range block.Entries {
+			if entryPath(block, o) == prev {
+				at = i + 1
+			}
+		}
+	}
+	block.Entries = append(block.Entries[:at], append([]*Entry{e}, block.Entries[at:]...)...)
+}
+
+// Adds comment lines at end of block like given one,
+// or at end of document if there is none.
+func (m *merger) trail(like *Block, lines []string) {
+	header := like.Header()
+	for i, b := range m.doc.Blocks {
+		if b.Header() != header {
+			continue
+		}
+		if i+1 < len(m.doc.Blocks) {
+			next := m.doc.Blocks[i+1]
+			next.Comments = append(lines, next.Comments...)
+			return
+		}
+		break
+	}
+	m.doc.Comments = append(m.doc.Comments, lines...)
+}
+
+// Drops blocks theirs removed and merge left empty.
+func (m *merger) dropBlocks() {
+	blocks := m.doc.Blocks[:1]
+	for _, b := range m.doc.Blocks[1:] {
+		header := b.Header()
+		if len(b.Entries) > 0 || !m.base.headers[header] || m.theirs.headers[header] {
+			blocks = append(blocks, b)
+		}
+	}
+	m.doc.Blocks = blocks
+}
+
+// Makes entries followed by comments merge added what they
+// read back as, so text written with += doesn't join them.
+func (m *merger) settle() {
+	for _, b := range m.doc.Blocks {
+		for i, e := range b.Entries {
+			if commented(m.doc.commentsAfter(b, i)) {
+				settle(e)
+			}
+		}
+	}
+}
+
+//------------------------------------------------------------
+// Index
+//------------------------------------------------------------
+
+// Entries of document by key path, last one of each path
+type docIndex struct {
+	doc *Document
+
+	entries map[string]*Entry
+	blocks  map[string]*Block
+
+	// Path written before path in same block
+	before map[string]string
+
+	// Paths in order of first appearance
+	paths []string
+
+	headers map[string]bool
+}
+
+func indexDocument(doc *Document) *docIndex {
+	idx := &docIndex{
+		doc:     doc,
+		entries: map[string]*Entry{},
+		blocks:  map[string]*Block{},
+		before:  map[string]string{},
+		headers: map[string]bool{},
+	}
+	for _, b := range doc.Blocks {
+		idx.headers[b.Header()] = true
+		prev := ""
+		for _, e := range b.Entries {
+			path := entryPath(b, e)
+			if idx.entries[path] == nil {
+				idx.paths = append(idx.paths, path)
+				idx.before[path] = prev
+			}
+			idx.entries[path], idx.blocks[path] = e, b
+			prev = path
+		}
+	}
+	return idx
+}
+
+// Returns block before given one, nil for first.
+func (idx *docIndex) previous(b *Block) *Block {
+	for i, o := range idx.doc.Blocks {
+		if o == b && i > 0 {
+			return idx.doc.Blocks[i-1]
+		}
+	}
+	return nil
+}
+
+// Returns key path of entry, includes by their path.
+func entryPath(b *Block, e *Entry) string {
+	if e.Kind == EntryInclude {
+		return pathPrefix(b) + "@include " + e.Value
+	}
+	return pathPrefix(b) + e.Key
+}
+
+//------------------------------------------------------------
+// Helpers
+//------------------------------------------------------------
+
+// Are entries same value, or both absent?
+func sameEntry(a, b *Entry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Kind != b.Kind || a.Value != b.Value || len(a.Items) != len(b.Items) {
+		return false
+	}
+	for i := range a.Items {
+		if a.Items[i] != b.Items[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merges list items as sets. Items theirs removed are
+// dropped, items theirs added follow item before them.
+func mergeItems(base, ours, theirs []string) []string {
+	inBase, inTheirs := map[string]bool{}, map[string]bool{}
+	for _, s := range base {
+		inBase[s] = true
+	}
+	for _, s := range theirs {
+		inTheirs[s] = true
+	}
+
+	var out []string
+	for _, s := range ours {
+		if !inBase[s] || inTheirs[s] {
+			out = append(out, s)
+		}
+	}
+	for i, s := range theirs {
+		if inBase[s] || indexOf(out, s) >= 0 {
+			continue
+		}
+		at := 0
+		if i > 0 {
+			if at = indexOf(out, theirs[i-1]) + 1; at == 0 {
+				at = len(out)
+			}
+		}
+		out = append(out[:at], append([]string{s}, out[at:]...)...)
+	}
+	return out
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns theirs entry as conflict comment lines. Entry is
+// written with =, as comment with += would join next line.
+func conflictComments(t *Entry) []string {
+	lines := []string{conflictTheirs}
+	if t == nil {
+		lines = append(lines, conflictRemoved)
+	} else {
+		e := *t
+		e.Append = false
+		lines = append(lines, "# "+e.String())
+		for _, item := range t.Items {
+			lines = append(lines, "#     "+item)
+		}
+	}
+	return append(lines, conflictOurs)
+}
+
+// Shows entry on one line, lists as [a, b].
+func showEntry(e *Entry) string {
+	switch {
+	case e == nil:
+		return "(removed)"
+	case e.Kind == EntryList:
+		return e.Key + " = [" + strings.Join(e.Items, ", ") + "]"
+	}
+	return e.String()
+}
+
+func copyDocument(doc *Document) *Document {
+	out := &Document{Comments: copyStrings(doc.Comments)}
+	for _, b := range doc.Blocks {
+		block := *b
+		block.doc, block.pending = nil, nil
+		block.Comments = copyStrings(b.Comments)
+		block.Entries = make([]*Entry, len(b.Entries))
+		for i, e := range b.Entries {
+			block.Entries[i] = copyEntry(e)
+		}
+		out.Blocks = append(out.Blocks, &block)
+	}
+	return out
+}
+
+func copyEntry(e *Entry) *Entry {
+	out := *e
+	out.Items = copyStrings(e.Items)
+	out.Comments = copyStrings(e.Comments)
+	return &out
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
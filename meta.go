@@ -0,0 +1,262 @@
+package skini
+
+/*
+Meta -- provenance of decoded values. Records where
+every key path got its value from and which defined
+keys ended up unused.
+
+Key paths:
+  key                  root key
+  section.key          key of [section]
+  map.name.key         key of [map.name]
+  map.name.sub.key     key of [map.name | sub]
+*/
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//------------------------------------------------------------
+// Meta
+//------------------------------------------------------------
+
+// Source of value
+type Source int
+
+const (
+	// Input file or reader
+	SourceFile Source = iota
+
+	// Default value, such as configparser DEFAULT section
+	SourceDefault
+
+	// Environment variable
+	SourceEnv
+
+	// Explicit override, such as command line flag
+	SourceOverride
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceFile:
+		return "file"
+	case SourceDefault:
+		return "default"
+	case SourceEnv:
+		return "env"
+	case SourceOverride:
+		return "override"
+	}
+	return fmt.Sprintf("source(%v)", int(s))
+}
+
+// Origin of value of key path
+type Origin struct {
+	Path string
+
+	// File name, empty if input is not a file
+	File string
+
+	// Line from 1, 0 if value didn't come from a line
+	Line int
+
+	// Line as written, trimmed
+	Raw string
+
+	Source Source
+}
+
+// Renders origin as file:line, followed by source
+// unless value came from file.
+func (o *Origin) String() string {
+	var pos string
+	switch {
+	case o.File != "" && o.Line > 0:
+		pos = fmt.Sprintf("%s:%v", o.File, o.Line)
+	case o.File != "":
+		pos = o.File
+	case o.Line > 0:
+		pos = fmt.Sprintf("line %v", o.Line)
+	}
+
+	if o.Source == SourceFile {
+		return pos
+	}
+	if pos == "" {
+		return o.Source.String()
+	}
+	return fmt.Sprintf("%s (%s)", pos, o.Source)
+}
+
+// Meta is optional output of parsing, see Options.Meta.
+// Same Meta can be passed to several parses, keys set
+// again by later parses replace earlier ones.
+type Meta struct {
+	// Origin of value by key path
+	Fields map[string]*Origin
+
+	// Keys defined but unused: replaced by later definition,
+	// not matching any field or default inherited by nothing
+	Unused []*Origin
+}
+
+// Gets origin of key path, nil if key was never set.
+func (m *Meta) Lookup(path string) *Origin {
+	return m.Fields[path]
+}
+
+// Records origin of value, replaced origin becomes unused.
+func (m *Meta) record(o *Origin) {
+	if m.Fields == nil {
+		m.Fields = map[string]*Origin{}
+	}
+	if old := m.Fields[o.Path]; old != nil {
+		m.Unused = append(m.Unused, old)
+	}
+	m.Fields[o.Path] = o
+}
+
+// Records definition that set nothing.
+func (m *Meta) unused(o *Origin) {
+	m.Unused = append(m.Unused, o)
+}
+
+// Renders key paths in sorted order with their origins,
+// followed by unused keys.
+func (m *Meta) String() string {
+	paths := make([]string, 0, len(m.Fields))
+	for path := range m.Fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var sb strings.Builder
+	for _, path := range paths {
+		o := m.Fields[path]
+		fmt.Fprintf(&sb, "%s\t%s\t%s\n", path, o, o.Raw)
+	}
+	if len(m.Unused) > 0 {
+		sb.WriteString("unused:\n")
+		for _, o := range m.Unused {
+			fmt.Fprintf(&sb, "%s\t%s\t%s\n", o.Path, o, o.Raw)
+		}
+	}
+	return sb.String()
+}
+
+// Key path of key in section or root.
+func keyPath(section, key string) string {
+	if section == "" {
+		return key
+	}
+	return section + "." + key
+}
+
+// Key path of key in map or submap.
+func mapPath(topmap, submap, key string) string {
+	if submap == "" {
+		return "map." + topmap + "." + key
+	}
+	return "map." + topmap + "." + submap + "." + key
+}
+
+//------------------------------------------------------------
+// Recorder
+//------------------------------------------------------------
+
+// Receiver recording origins of values it passes on.
+// Also skips keys without fields if asked to.
+type recorder struct {
+	next receiver
+
+	// Nil if origins are not recorded
+	meta *Meta
+
+	ignoreUnknown bool
+
+	// Position of line being parsed
+	file   string
+	line   int
+	raw    string
+	source Source
+}
+
+// Sets position of line being parsed.
+func (r *recorder) at(file string, line int, raw string, source Source) {
+	r.file, r.line, r.raw, r.source = file, line, raw, source
+}
+
+// Records outcome of setting key path. Raw line of
+// secret is redacted.
+func (r *recorder) done(path string, secret bool, err error) error {
+	unknown := err != nil && r.ignoreUnknown && errors.Is(err, ErrUnknownKey)
+	if err != nil && !unknown {
+		return err
+	}
+	if r.meta != nil {
+		o := &Origin{Path: path, File: r.file, Line: r.line, Raw: r.raw, Source: r.source}
+		if secret {
+			o.Raw = redactRaw(o.Raw)
+		}
+		if unknown {
+			r.meta.unused(o)
+		} else {
+			r.meta.record(o)
+		}
+	}
+	return nil
+}
+
+func (r *recorder) touchSection(section string) {
+	r.next.touchSection(section)
+}
+
+func (r *recorder) hasField(section, key string) bool {
+	return r.next.hasField(section, key)
+}
+
+func (r *recorder) isSecret(section, key string) bool {
+	return r.next.isSecret(section, key)
+}
+
+func (r *recorder) setField(section, key, value string) error {
+	err := r.next.setField(section, key, value)
+	return r.done(keyPath(section, key), r.isSecret(section, key), err)
+}
+
+func (r *recorder) setEmpty(section, key string) error {
+	return r.done(keyPath(section, key), false, r.next.setEmpty(section, key))
+}
+
+func (r *recorder) unsetField(section, key string) error {
+	return r.done(keyPath(section, key), false, r.next.unsetField(section, key))
+}
+
+// List is recorded once, at its first item.
+func (r *recorder) addSliceItem(section, key string, index int, value string) error {
+	err := r.next.addSliceItem(section, key, index, value)
+	if index == 0 {
+		return r.done(keyPath(section, key), false, err)
+	}
+	if r.ignoreUnknown && errors.Is(err, ErrUnknownKey) {
+		return nil
+	}
+	return err
+}
+
+func (r *recorder) checkArrayLen(section, key string, count int) error {
+	err := r.next.checkArrayLen(section, key, count)
+	if r.ignoreUnknown && errors.Is(err, ErrUnknownKey) {
+		return nil
+	}
+	return err
+}
+
+func (r *recorder) addMapItem(topmap, submap, key, value string) error {
+	err := r.next.addMapItem(topmap, submap, key, value)
+	return r.done(mapPath(topmap, submap, key), r.isSecret(topmap, ""), err)
+}
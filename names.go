@@ -0,0 +1,230 @@
+package skini
+
+/*
+Names -- matching of input keys, sections and map names
+to struct field names.
+*/
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//------------------------------------------------------------
+// Name matching strategies
+//------------------------------------------------------------
+
+// Name matching strategy. Both the input name and the struct
+// field name (or its `skini:"name"` tag) are normalized by
+// the strategy, and match when normalized forms are equal.
+type NameMatch int
+
+const (
+	// Camelcase input name and compare to field name as is:
+	// server.http --> ServerHttp. This is the default.
+	NamesCamel NameMatch = iota
+
+	// Input name must be exactly the field name: LogDir
+	NamesExact
+
+	// Same as NamesCamel but ignoring case: logdir, LOGDIR, log.dir
+	NamesFold
+
+	// Ignores case, dots, dashes, underscores and spaces:
+	// log_dir, log-dir, Log Dir, log.dir
+	NamesNormalized
+
+	// Uses Options.NameFunc to normalize names
+	NamesFunc
+)
+
+// Returns function normalizing names for given strategy.
+func (m NameMatch) normalizer(fn func(string) string) (func(string) string, error) {
+	switch m {
+	case NamesCamel:
+		return toFieldName, nil
+	case NamesExact:
+		return func(s string) string { return s }, nil
+	case NamesFold:
+		return func(s string) string { return strings.ToLower(toFieldName(s)) }, nil
+	case NamesNormalized:
+		return normalizeName, nil
+	case NamesFunc:
+		if fn == nil {
+			return nil, fmt.Errorf("error, NamesFunc strategy requires Options.NameFunc")
+		}
+		return fn, nil
+	}
+	return nil, fmt.Errorf("error, unknown name matching strategy: %v", int(m))
+}
+
+// Lowercases and removes separators from name.
+// Example: Log_Dir --> logdir
+func normalizeName(s string) string {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch r {
+		case '.', '-', '_', ' ':
+		default:
+			out = append(out, unicode.ToLower(r))
+		}
+	}
+	return string(out)
+}
+
+//------------------------------------------------------------
+// Struct field lookup
+//------------------------------------------------------------
+
+// Name of field as seen by input: either from
+// `skini:"name"` tag or the Go field name itself.
+// Returns empty name for fields that must be skipped.
+func fieldInputName(sf reflect.StructField) string {
+	if sf.PkgPath != "" && !sf.Anonymous {
+		// Unexported
+		return ""
+	}
+	tag := sf.Tag.Get("skini")
+	if tag == "-" {
+		return ""
+	}
+	if i := strings.Index(tag, ","); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag != "" {
+		return tag
+	}
+	return sf.Name
+}
+
+// Checks if field's `skini:"name,opt,..."` tag has option.
+func hasTagOption(sf reflect.StructField, opt string) bool {
+	tag := sf.Tag.Get("skini")
+	i := strings.Index(tag, ",")
+	if i < 0 {
+		return false
+	}
+	for _, o := range strings.Split(tag[i+1:], ",") {
+		if strings.TrimSpace(o) == opt {
+			return true
+		}
+	}
+	return false
+}
+
+//------------------------------------------------------------
+// Compiled per-type plans
+//------------------------------------------------------------
+
+// Field lookup plan for struct type, built once per type
+// and name matching strategy.
+type typePlan struct {
+	// Fields by normalized input name
+	fields map[string]*fieldPlan
+
+	// Normalizer the plan was built with
+	normalize func(string) string
+}
+
+// Plan of single field
+type fieldPlan struct {
+	name  string
+	index []int
+	depth int
+
+	// Set when several fields match the same name
+	err error
+
+	// Tagged `skini:",secret"`
+	secret bool
+
+	// Plan of nested struct, built on first use
+	once sync.Once
+	plan *typePlan
+}
+
+// Cache key of plans for builtin name strategies
+type planKey struct {
+	typ   reflect.Type
+	names NameMatch
+}
+
+// Plans for builtin name strategies, shared by all parses
+var planCache sync.Map
+
+// Gets cached plan for struct type and builtin strategy.
+func cachedPlan(typ reflect.Type, names NameMatch, normalize func(string) string) *typePlan {
+	key := planKey{typ, names}
+	if p, ok := planCache.Load(key); ok {
+		return p.(*typePlan)
+	}
+	p, _ := planCache.LoadOrStore(key, newTypePlan(typ, normalize))
+	return p.(*typePlan)
+}
+
+// Builds lookup plan for struct type.
+func newTypePlan(typ reflect.Type, normalize func(string) string) *typePlan {
+	p := &typePlan{fields: map[string]*fieldPlan{}, normalize: normalize}
+	p.collect(typ, nil, 0, map[reflect.Type]bool{typ: true})
+	return p
+}
+
+// Collects fields of struct type, promoting fields of embedded
+// structs and struct pointers. Shallower fields win, same depth
+// fields collide. Seen holds types embedding this one, so
+// struct embedding pointer to itself is not walked forever.
+func (p *typePlan) collect(typ reflect.Type, prefix []int, depth int, seen map[reflect.Type]bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		index := append(append([]int{}, prefix...), i)
+
+		// Embedded struct without tag: promote its fields
+		if sf.Anonymous && sf.Tag.Get("skini") == "" {
+			t := sf.Type
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() == reflect.Struct && !seen[t] {
+				seen[t] = true
+				p.collect(t, index, depth+1, seen)
+				delete(seen, t)
+			}
+			continue
+		}
+
+		name := fieldInputName(sf)
+		if name == "" {
+			continue
+		}
+		norm := p.normalize(name)
+
+		prev := p.fields[norm]
+		switch {
+		case prev == nil || depth < prev.depth:
+			p.fields[norm] = &fieldPlan{name: sf.Name, index: index, depth: depth, secret: hasTagOption(sf, "secret")}
+		case depth == prev.depth && prev.err == nil:
+			prev.err = fmt.Errorf("error, fields %s and %s both match key: %s", prev.name, sf.Name, norm)
+		}
+	}
+}
+
+// Finds field plan by normalized name.
+// Returns nil plan if no such field.
+func (p *typePlan) lookup(norm string) (fp *fieldPlan, err error) {
+	fp = p.fields[norm]
+	if fp != nil && fp.err != nil {
+		return nil, fp.err
+	}
+	return
+}
+
+// Gets plan of nested struct type this field holds.
+func (fp *fieldPlan) nested(typ reflect.Type, parent *typePlan) *typePlan {
+	fp.once.Do(func() {
+		fp.plan = newTypePlan(typ, parent.normalize)
+	})
+	return fp.plan
+}
@@ -0,0 +1,384 @@
+package skini
+
+/*
+Overlay -- command line flags and environment variables
+over decoded struct. Layers from lowest to highest:
+  defaults                  values of struct before parsing
+  input                     ParseFile and friends
+  environment               APP_SERVER_HTTP_PORT=8080
+  flags                     --server.http.port=8080
+
+Names of flags and variables come from the same field
+mapping the parser uses, so every key that can be set
+in input can be overridden:
+  LogDir                    --log.dir    APP_LOG_DIR
+  ServerHttp.Port           --server.http.port
+  Port `skini:"listen"`     --listen     APP_LISTEN
+Lists take comma separated variables or repeated flags.
+Maps are not overridable.
+*/
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// Overlay of flags and environment onto struct
+type Overlay struct {
+	target *reflector
+	opts   *Options
+	keys   []*overlayKey
+}
+
+// Key that can be overridden
+type overlayKey struct {
+	// Input names, section empty for root
+	section string
+	key     string
+
+	// Flag name and environment variable
+	path string
+	env  string
+
+	list    bool
+	boolean bool
+
+	// Value before parsing
+	def string
+
+	// Flag values, nil if flag not given
+	flag []string
+}
+
+// Creates overlay of struct. Call before parsing so that
+// current values are shown as defaults. Environment
+// variables are prefixed with prefix and underscore
+// unless prefix is empty. Options are those of parsing,
+// Meta records overrides.
+func NewOverlay(target interface{}, prefix string, opts *Options) (o *Overlay, err error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	elem, err := getElem(target)
+	if err != nil {
+		return
+	}
+	r, err := newReflector(elem, opts)
+	if err != nil {
+		return
+	}
+
+	o = &Overlay{target: r, opts: opts}
+	o.collect(r.elem, r.plan, "", prefix, false)
+	return o, nil
+}
+
+//------------------------------------------------------------
+// Keys
+//------------------------------------------------------------
+
+// Collects overridable keys of root or section struct,
+// promoting embedded structs as plans do.
+func (o *Overlay) collect(elem reflect.Value, plan *typePlan, section, prefix string, secret bool) {
+	typ := elem.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		field := elem.Field(i)
+
+		if sf.Anonymous && sf.Tag.Get("skini") == "" {
+			if f := reflect.Indirect(field); f.Kind() == reflect.Struct {
+				o.collect(f, plan, section, prefix, secret)
+			}
+			continue
+		}
+
+		name := o.inputName(sf, plan)
+		if name == "" {
+			continue
+		}
+		isSecret := secret || hasTagOption(sf, "secret")
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch ft.Kind() {
+		case reflect.Struct:
+			if section != "" {
+				continue
+			}
+			fp, _ := plan.lookup(o.target.norm(name))
+			if fp == nil {
+				continue
+			}
+			nested := reflect.Indirect(field)
+			if !nested.IsValid() {
+				nested = reflect.New(ft).Elem()
+			}
+			o.collect(nested, fp.nested(ft, plan), name, prefix, isSecret)
+			continue
+		case reflect.Map:
+			continue
+		}
+
+		k := &overlayKey{
+			section: section,
+			key:     name,
+			path:    keyPath(section, name),
+			list:    ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array,
+			boolean: ft.Kind() == reflect.Bool,
+		}
+		k.env = envName(prefix, k.path)
+		k.def = formatDefault(field, k.list, isSecret)
+		o.keys = append(o.keys, k)
+	}
+}
+
+// Input name of field: dotted lowercase form of field name,
+// server.http for ServerHttp, when it finds the field back.
+// Otherwise tag name or field name as is.
+func (o *Overlay) inputName(sf reflect.StructField, plan *typePlan) string {
+	name := fieldInputName(sf)
+	if name == "" || name != sf.Name {
+		return name
+	}
+	dotted := dottedName(name)
+	if fp, _ := plan.lookup(o.target.norm(dotted)); fp != nil && fp.name == sf.Name {
+		return dotted
+	}
+	return name
+}
+
+// Splits camelcase name by dots: LogDir --> log.dir
+// Runs of capitals stay together: HTTPPort --> http.port
+func dottedName(s string) string {
+	runes := []rune(s)
+	var sb strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			sb.WriteByte('.')
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// Environment variable of key path: APP_SERVER_HTTP_PORT
+func envName(prefix, path string) string {
+	name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_", " ", "_").Replace(path))
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// Formats current value of field for help output.
+func formatDefault(field reflect.Value, list, secret bool) string {
+	var items []string
+	if list {
+		f := reflect.Indirect(field)
+		if f.IsValid() {
+			for i := 0; i < f.Len(); i++ {
+				s, _ := formatField(f.Index(i), "", secret)
+				items = append(items, s)
+			}
+		}
+	} else {
+		s, _ := formatField(field, "", secret)
+		items = append(items, s)
+	}
+	return strings.Join(items, ",")
+}
+
+//------------------------------------------------------------
+// Flags
+//------------------------------------------------------------
+
+// Flag value collecting what command line gave
+type overlayFlag struct {
+	k *overlayKey
+}
+
+func (f *overlayFlag) String() string {
+	if f.k == nil {
+		return ""
+	}
+	return f.k.def
+}
+
+// Repeated list flags add items, others replace.
+func (f *overlayFlag) Set(value string) error {
+	if f.k.list {
+		f.k.flag = append(f.k.flag, value)
+	} else {
+		f.k.flag = []string{value}
+	}
+	return nil
+}
+
+// Boolean keys can be given without value.
+func (f *overlayFlag) IsBoolFlag() bool {
+	return f.k.boolean
+}
+
+// Registers flag of every key on flag set. Flags are
+// applied by Apply, after parsing. Flag set's usage
+// is replaced by PrintDefaults.
+func (o *Overlay) RegisterFlags(fs *flag.FlagSet) {
+	for _, k := range o.keys {
+		fs.Var(&overlayFlag{k}, k.path, "env "+k.env)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		o.PrintDefaults(fs.Output())
+	}
+}
+
+//------------------------------------------------------------
+// Applying
+//------------------------------------------------------------
+
+// Applies environment variables, then flags given on
+// command line. Call after parsing. Meta of options
+// records them with SourceEnv and SourceOverride.
+func (o *Overlay) Apply() (err error) {
+	var recv receiver = o.target
+	if secrets := o.opts.Secrets; secrets != nil {
+		recv = &resolver{next: recv, ctx: context.Background(), secrets: secrets}
+	}
+	rec := &recorder{next: recv, meta: o.opts.Meta}
+	if rec.meta != nil {
+		recv = rec
+	}
+
+	for _, k := range o.keys {
+		value, ok := os.LookupEnv(k.env)
+		if !ok {
+			continue
+		}
+		var items []string
+		if k.list && value != "" {
+			items = strings.Split(value, ",")
+		}
+		rec.at("", 0, k.env+"="+value, SourceEnv)
+		if err = o.apply(recv, k, value, items); err != nil {
+			return fmt.Errorf("error applying %s: %w", k.env, err)
+		}
+	}
+
+	for _, k := range o.keys {
+		if k.flag == nil {
+			continue
+		}
+		value := strings.Join(k.flag, ",")
+		rec.at("", 0, "--"+k.path+"="+value, SourceOverride)
+		if err = o.apply(recv, k, k.flag[len(k.flag)-1], k.flag); err != nil {
+			return fmt.Errorf("error applying --%s: %w", k.path, err)
+		}
+	}
+	return
+}
+
+// Sets key to value, or list key to items. List is
+// emptied first, without recording unless it stays empty.
+func (o *Overlay) apply(recv receiver, k *overlayKey, value string, items []string) (err error) {
+	if !k.list {
+		if value == "" {
+			return recv.setEmpty(k.section, k.key)
+		}
+		return recv.setField(k.section, k.key, value)
+	}
+
+	if len(items) == 0 {
+		return recv.unsetField(k.section, k.key)
+	}
+	if err = o.target.unsetField(k.section, k.key); err != nil {
+		return
+	}
+	for i, item := range items {
+		if err = recv.addSliceItem(k.section, k.key, i, strings.TrimSpace(item)); err != nil {
+			return
+		}
+	}
+	return recv.checkArrayLen(k.section, k.key, len(items))
+}
+
+//------------------------------------------------------------
+// Help
+//------------------------------------------------------------
+
+// Prints every key with its flag, environment variable,
+// default and where its current value came from.
+// Sources are known when options have Meta.
+func (o *Overlay) PrintDefaults(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	origins := o.origins()
+	fmt.Fprintln(tw, "  FLAG\tENV\tDEFAULT\tSOURCE")
+	for _, k := range o.keys {
+		def := k.def
+		if def == "" {
+			def = `""`
+		}
+		fmt.Fprintf(tw, "  --%s\t%s\t%s\t%s\n", k.path, k.env, def, o.source(k, origins))
+	}
+	tw.Flush()
+}
+
+// Indexes origins of Meta by normalized path. Input may
+// spell key differently, logDir for log.dir. Of paths
+// spelled differently the first in sorted order wins.
+func (o *Overlay) origins() map[string]*Origin {
+	meta := o.opts.Meta
+	if meta == nil {
+		return nil
+	}
+	paths := make([]string, 0, len(meta.Fields))
+	for path := range meta.Fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	origins := make(map[string]*Origin, len(paths))
+	for _, path := range paths {
+		if norm := o.target.norm(path); origins[norm] == nil {
+			origins[norm] = meta.Fields[path]
+		}
+	}
+	return origins
+}
+
+// Where current value of key came from, by path as
+// written or by normalized path.
+func (o *Overlay) source(k *overlayKey, origins map[string]*Origin) string {
+	if meta := o.opts.Meta; meta != nil {
+		origin := meta.Lookup(k.path)
+		if origin == nil {
+			origin = origins[o.target.norm(k.path)]
+		}
+		if origin != nil && origin.String() != "" {
+			return origin.String()
+		}
+	}
+	if k.flag != nil {
+		return SourceOverride.String()
+	}
+	return "default"
+}
+
+// Lists flag names in order of struct fields.
+func (o *Overlay) Keys() []string {
+	keys := make([]string, len(o.keys))
+	for i, k := range o.keys {
+		keys[i] = k.path
+	}
+	return keys
+}
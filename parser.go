@@ -3,43 +3,14 @@ package skini
 /*
 Parser -- responsible for parsing input line by line.
 Parser recognizes each line's expression type and asks
-Reflector to add values to corresponding target field.
+receiver (Reflector or schemaless map builder) to add
+values to corresponding target field.
 */
 
 import (
 	"fmt"
-	"reflect"
-	"regexp"
 )
 
-//------------------------------------------------------------
-// Regex
-//------------------------------------------------------------
-
-// Looks like: [section]
-var reLikeSection = regexp.MustCompile(`^\[[a-zA-Z0-9\.\|\s]+\]$`)
-
-// Looks like map ? [map.*]
-var reLikeMap = regexp.MustCompile(`^\[\s*map\..*\s*\]$`)
-
-// Looks like: key [+]= value
-var reLikeKeyValue = regexp.MustCompile(`^[^=]+\s+\+?=\s*.*$`)
-
-// key [+]= value
-var reKeyValue = regexp.MustCompile(`^(?P<key>[^=]+)\s+\+?=\s*(?P<value>.*)$`)
-
-// key += value
-var reKeyValuePlus = regexp.MustCompile(`^(?P<key>[^=]+)\s+\+=\s*(?P<value>.*)$`)
-
-// Is this a map ? [map.*]
-var reIsMap = regexp.MustCompile(`^\[\s*map\..*\s*\]$`)
-
-// Map elements: [map.name | keyname ]
-var reMap = regexp.MustCompile(`^\[\s*map\.(?P<name>[a-zA-Z0-9_\.]+)(?P<suffix>\s*\|\s*(?P<key>[a-zA-Z0-9_\-\.\*]*))?\s*\]$`)
-
-// [section]
-var reSection = regexp.MustCompile(`^\[\s*(?P<key>[a-zA-Z0-9\.]+)\s*\]$`)
-
 //------------------------------------------------------------
 // Parser structures
 //------------------------------------------------------------
@@ -54,6 +25,24 @@ const (
 	ExprVal
 )
 
+// Receiver of parsed values: struct reflector
+// or schemaless map builder.
+type receiver interface {
+	touchSection(section string)
+	hasField(section, key string) bool
+	isSecret(section, key string) bool
+	setField(section, key, value string) error
+	setEmpty(section, key string) error
+	unsetField(section, key string) error
+	addSliceItem(section, key string, index int, value string) error
+	checkArrayLen(section, key string, count int) error
+	addMapItem(topmap, submap, key, value string) error
+}
+
+// Value that unsets key: leaves pointers, slices and
+// maps nil, strings empty, and removes key from map
+const nullValue = "@null"
+
 // Expresson values
 type exprValues struct {
 	name  string
@@ -67,20 +56,25 @@ type parserState struct {
 	capMap     string
 	capSubmap  string
 	capList    string
+
+	// Number of items added to current list
+	listCount int
 }
 
 //------------------------------------------------------------
 // Line by line parser
 //------------------------------------------------------------
 
-func parseLine(target *reflect.Value, lineA, lineB string, state *parserState) (err error) {
+func parseLine(d *decoder, tok, next *token, state *parserState) (err error) {
+	target := d.target
+
 	// Be ready to catch panic and report which line caused it
 	defer func() {
 		if err := recover(); err != nil {
 			fmt.Printf("[PANIC] for:\n")
 			fmt.Printf("  State.section = %v, .map = %v, .submap = %v, .list = %v\n",
 				state.capSection, state.capMap, state.capSubmap, state.capList)
-			fmt.Printf("  lineA: %s\n  lineB: %s\n", lineA, lineB)
+			fmt.Printf("  line %v: %s\n  line %v: %s\n", tok.line, tok.raw, next.line, next.raw)
 			fmt.Printf("Panic type: %v\n", err)
 			//fmt.Printf("Panic type: \v%v\n", debug.Stack())
 			panic("[skini] Aborted, paniced during parse")
@@ -88,15 +82,12 @@ func parseLine(target *reflect.Value, lineA, lineB string, state *parserState) (
 	}()
 
 	// Skip skippables (comments, etc.)
-	if isSkip(lineA) {
+	if tok.typ == tokComment {
 		return
 	}
 
-	// Parse line as an expression
-	typ, vals, err := parseExpr(lineA, lineB)
-	if err != nil {
-		return
-	}
+	// Parse token as an expression
+	typ, vals := parseExpr(tok, next)
 
 	/* Uncomment for capture debugging:
 	var debugCapture = ""
@@ -114,12 +105,20 @@ func parseLine(target *reflect.Value, lineA, lineB string, state *parserState) (
 		typ = ExprKeyVal
 	}
 
+	// Anything but value ends current list
+	if typ != ExprVal {
+		if err = closeList(target, state); err != nil {
+			return
+		}
+	}
+
 	switch typ {
 
 	case ExprSection:
 		//fmt.Printf("\t==> SECTION, name = %s\n", vals.name)
 		state.capSection = vals.name
 		state.capMap, state.capSubmap, state.capList = "", "", ""
+		target.touchSection(vals.name)
 
 	case ExprMap:
 		//fmt.Printf("\t==> MAP, name = %s, value = %s\n", vals.name, vals.value)
@@ -129,6 +128,10 @@ func parseLine(target *reflect.Value, lineA, lineB string, state *parserState) (
 	case ExprList:
 		//fmt.Printf("\t\t[%s] LIST, name = %s\n", debugCapture, vals.name)
 		state.capList = vals.name
+		state.listCount = 0
+		if d.budget.opts.ReplaceLists && d.base.hasField(state.capSection, vals.name) {
+			err = d.base.unsetField(state.capSection, vals.name)
+		}
 
 	// K = V
 	case ExprKeyVal:
@@ -137,10 +140,21 @@ func parseLine(target *reflect.Value, lineA, lineB string, state *parserState) (
 
 		if state.capMap != "" {
 			// KV in Map: either map[s]s or map[s]map[s]s
-			err = addMapItem(target, state.capMap, state.capSubmap, vals.name, vals.value)
+			if err = d.mapEntry(state.capMap); err != nil {
+				return
+			}
+			err = target.addMapItem(state.capMap, state.capSubmap, vals.name, vals.value)
 		} else {
-			// KV in Section: simple field
-			err = setField(target, state.capSection, vals.name, vals.value)
+			// KV in Section: simple field, empty value
+			// is decided by field's kind
+			switch vals.value {
+			case "":
+				err = target.setEmpty(state.capSection, vals.name)
+			case nullValue:
+				err = target.unsetField(state.capSection, vals.name)
+			default:
+				err = target.setField(state.capSection, vals.name, vals.value)
+			}
 		}
 
 	// K = ...Vi
@@ -151,230 +165,73 @@ func parseLine(target *reflect.Value, lineA, lineB string, state *parserState) (
 			fmt.Printf("[SKINI] SKIPPING: Not supported: list in map: [%s] value = %s\n", state.capList, vals.value)
 		} else {
 			// V in Section: slice item, either top level or section
-			err = addSliceItem(target, state.capSection, state.capList, vals.value)
+			if err = d.budget.listItem(state.listCount); err != nil {
+				return
+			}
+			err = target.addSliceItem(state.capSection, state.capList, state.listCount, vals.value)
+			state.listCount++
 		}
 
 	default:
-		err = fmt.Errorf("\tOOPS! Parser doesn't know how to handle this line: %s\n", lineA)
+		err = fmt.Errorf("\tOOPS! Parser doesn't know how to handle this line: %s\n", tok.raw)
 	}
 
 	return
 }
 
-//------------------------------------------------------------
-// Expression parser
-//------------------------------------------------------------
-
-func parseExpr(lineA, lineB string) (typ int, values *exprValues, err error) {
-
-	// Map ? Must check before section
-	if name, key, ok := isMap(lineA); ok {
-		typ = ExprMap
-		values = &exprValues{name, key}
+// Ends currently captured list, if any.
+// Lists going into arrays must fill them completely.
+// List without items is the same as empty value.
+func closeList(target receiver, state *parserState) (err error) {
+	if state.capList == "" || state.capMap != "" {
 		return
 	}
-
-	// Section ?
-	if name, ok := isSection(lineA); ok {
-		typ = ExprSection
-		values = &exprValues{name, ""}
-		return
-	}
-
-	// List ?
-	if name, ok := isList(lineA, lineB); ok {
-		typ = ExprList
-		values = &exprValues{name, ""}
-		return
-	}
-
-	// KV ?
-	if name, value, ok := isKeyValue(lineA); ok {
-		typ = ExprKeyVal
-		values = &exprValues{name, value}
-		return
-	}
-
-	// V ?
-	if value, ok := isValue(lineA); ok {
-		typ = ExprVal
-		values = &exprValues{"", value}
-		return
+	if state.listCount == 0 {
+		err = target.setEmpty(state.capSection, state.capList)
+	} else {
+		err = target.checkArrayLen(state.capSection, state.capList, state.listCount)
 	}
-
-	err = fmt.Errorf("unrecognized expression: %s\n", lineA)
+	state.capList, state.listCount = "", 0
 	return
 }
 
 //------------------------------------------------------------
-// Expression type recognizers
+// Expression parser
 //------------------------------------------------------------
 
-// Is skippable ?
-func isSkip(line string) bool {
-	if line == "" || line[0] == '#' || line[0] == ';' {
-		return true
-	}
-	return false
-}
-
-// Is anything looking like a section ?
-func isLikeSection(line string) bool {
-	if line == "" || line[0] != '[' {
-		return false
-	}
-
-	match := reLikeSection.FindStringSubmatch(line)
-	if match == nil {
-		return false
-	}
-	return true
-}
-
-// Is anything looking like a map ?
-func isLikeMap(line string) bool {
-	if line == "" || line[0] != '[' {
-		return false
-	}
-
-	match := reLikeMap.FindStringSubmatch(line)
-	if match == nil {
-		return false
-	}
-	return true
-}
+// Recognizes expression of token. Next token
+// tells if key with empty value starts a list.
+func parseExpr(tok, next *token) (typ int, values *exprValues) {
+	switch tok.typ {
 
-// Is anything looking like a section ?
-func isLikeKeyValue(line string) bool {
-	match := reLikeKeyValue.FindStringSubmatch(line)
-	if match == nil {
-		return false
-	}
-	return true
-}
+	case tokMap:
+		return ExprMap, &exprValues{tok.name, tok.value}
 
-// Is map definition ?
-func isMap(line string) (name, key string, ok bool) {
-	match := reIsMap.FindStringSubmatch(line)
-	if match == nil {
-		return
-	}
-	match = reMap.FindStringSubmatch(line)
-	if match == nil {
-		return
-	}
+	case tokSection:
+		return ExprSection, &exprValues{tok.name, ""}
 
-	for i, capt := range reMap.SubexpNames() {
-		switch capt {
-		case "name":
-			name = match[i]
-		case "key":
-			key = match[i]
+	case tokKeyValue:
+		// List: Key = "" followed by Value
+		if tok.value == "" && isValue(next) {
+			return ExprList, &exprValues{tok.name, ""}
 		}
-	}
-
-	// At least name must be present
-	if name != "" {
-		ok = true
-	}
-
-	return
-}
+		return ExprKeyVal, &exprValues{tok.name, tok.value}
 
-// Is section definition ? Section is considered after
-// map test failed.
-func isSection(line string) (key string, ok bool) {
-	if line == "" || line[0] != '[' {
-		return
-	}
-
-	match := reSection.FindStringSubmatch(line)
-	if match == nil {
-		return
-	}
-
-	for i, capt := range reSection.SubexpNames() {
-		switch capt {
-		case "key":
-			return match[i], true
-		}
+	case tokValue:
+		return ExprVal, &exprValues{"", tok.value}
 	}
-	return
+	return ExprNone, nil
 }
 
-// Is Key = Value ?
-func isKeyValue(line string) (key, value string, ok bool) {
-	match := reKeyValue.FindStringSubmatch(line)
-	if match == nil {
-		return
-	}
-
-	for i, capt := range reKeyValue.SubexpNames() {
-		switch capt {
-		case "key":
-			key = match[i]
-		case "value":
-			value = match[i]
-		}
-	}
-
-	if key != "" {
-		ok = true
-	}
-	return
-}
-
-// Is Key += Value ?
-func isKeyValuePlus(line string) bool {
-	match := reKeyValuePlus.FindStringSubmatch(line)
-	if match == nil {
-		return false
-	}
-	return true
-}
-
-// Is Key Value List: Key = "" followed by Value ?
-func isList(lineA, lineB string) (key string, ok bool) {
-	var value string
-
-	// Line A
-	// Must look like "key = val"
-	key, value, ok = isKeyValue(lineA)
-	if !ok {
-		return
-	}
-
-	// Line B
-	// To qualify as a list, the value must be empty
-	if value == "" {
-		if value, ok = isValue(lineB); ok {
-			return
-		}
-	} else {
-		ok = false
-	}
-
-	return
-}
-
-// Is Value ?
-func isValue(line string) (value string, ok bool) {
-	// Value is anything that doesn't look like:
-	// - section
-	// - section map
-	// - key value pair
-
-	if _, ok = isSection(line); ok {
-		return line, false
-	}
-
-	if _, _, ok = isMap(line); ok {
-		return line, false
-	}
-
-	if _, _, ok = isKeyValue(line); ok {
-		return line, false
+// Is Value ? Value is anything that doesn't look like:
+// section, section map or key value pair.
+// Comments count as values too.
+func isValue(tok *token) bool {
+	switch tok.typ {
+	case tokValue:
+		return true
+	case tokComment:
+		return !tok.likeKeyValue
 	}
-
-	return line, true
+	return false
 }
@@ -1,32 +1,97 @@
 package skini
 
 /*
-Reader -- reads input line by line and
+Reader -- reads input token by token and
 calls Parser to parse each line.
 */
 
 import (
-	"bufio"
+	"context"
 	"fmt"
 	"io"
-    "reflect"
+	"io/fs"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
+//------------------------------------------------------------
+// Decoder
+//------------------------------------------------------------
+
+// Decoder reads input with its includes into target.
+type decoder struct {
+    target receiver
+    budget *budget
+
+    // Target without wrappers, changes to it are not recorded
+    base receiver
+
+    // Stack of files being read, top level input first.
+    // Name is empty for input that is not a file.
+    files []string
+
+    // File system of files, of OS if nil
+    fsys fs.FS
+
+    // Entries added to each map
+    mapSizes map[string]int
+
+    // Syntax of input and state of configparser dialect
+    dialect      Dialect
+    configParser *configParserState
+
+    // Records origins of values, nil if not asked to
+    recorder *recorder
+}
+
+// Creates decoder for target within context and options.
+func newDecoder(ctx context.Context, target receiver, opts *Options) *decoder {
+    d := &decoder{
+        target:   target,
+        base:     target,
+        budget:   newBudget(ctx, opts),
+        mapSizes: map[string]int{},
+    }
+    d.dialect = d.budget.opts.Dialect
+
+    // References are resolved before values reach target
+    if secrets := d.budget.opts.Secrets; secrets != nil {
+        d.target = &resolver{next: target, ctx: d.budget.ctx, secrets: secrets}
+    }
+
+    if opts := d.budget.opts; opts.Meta != nil || opts.IgnoreUnknown {
+        d.recorder = &recorder{next: d.target, meta: opts.Meta, ignoreUnknown: opts.IgnoreUnknown}
+        d.target = d.recorder
+    }
+    if d.dialect == DialectConfigParser {
+        d.configParser = &configParserState{vars: map[string]string{}, defaultIndex: map[string]int{}}
+    }
+    return d
+}
+
+// Name of the file being read
+func (d *decoder) file() string {
+    return d.files[len(d.files) - 1]
+}
+
 //------------------------------------------------------------
 // Read and parses input line by line
 //------------------------------------------------------------
 
-// Parse whole input.
-func parseInput(target *reflect.Value, r io.Reader) (err error) {
-    var lineA, lineB string
-	scanner := bufio.NewScanner(r)
+// Parse whole top level input.
+func (d *decoder) parseInput(r io.Reader, filename string) (err error) {
+    d.files = append(d.files, filename)
+    defer func() { d.files = d.files[:len(d.files) - 1] }()
+
+    lex := newLexer(r, d.budget)
 
     // Read first line
-    if lineA, err = readNextLine(scanner); err != nil {
-        return
+    tok, err := lex.next()
+    if err != nil {
+        return errorAt(d.file(), tok, err)
     }
-    if lineA == "" {
+    if tok.typ == tokEOF && len(d.files) == 1 {
 		return fmt.Errorf("error, file is empty")
     }
 
@@ -34,148 +99,216 @@ func parseInput(target *reflect.Value, r io.Reader) (err error) {
     pstate := &parserState{}
 
     // Read consecutive lines
-    //i := 0
-    for {
-        if lineA == "" {
-            break
+    for tok.typ != tokEOF {
+        // Special case of 'k += v', stick all lines together
+        if tok.plus {
+            if err = appendLines(lex, tok, d.budget); err != nil {
+                return errorAt(d.file(), tok, err)
+            }
+        }
+        // Configparser values continue on indented lines
+        if d.dialect == DialectConfigParser && tok.typ == tokKeyValue {
+            if err = d.appendIndented(lex, tok); err != nil {
+                return errorAt(d.file(), tok, err)
+            }
         }
 
-        if lineB, err = readNextLine(scanner); err != nil {
-            return
+        // Look ahead line
+        next, err := lex.peek()
+        if err != nil {
+            return errorAt(d.file(), tok, err)
         }
 
-        // Special case of 'k += v', stick all lines together
-        if isKeyValuePlus(lineA) {
-            if lineA, lineB, err = appendLines(scanner, lineA, lineB); err != nil {
-                return
-            }
+        // Lists are recorded at their key
+        if d.recorder != nil && tok.typ != tokValue && tok.typ != tokComment {
+            d.recorder.at(d.file(), tok.line, tok.raw, SourceFile)
         }
 
-        // DEBUG
-        //fmt.Println(i, " : ", lineA)
-        //i ++
+        // Parse line, includes are read in place
+        if tok.typ == tokInclude {
+            if err = closeList(d.target, pstate); err == nil {
+                err = d.include(tok.value)
+            }
+        } else if d.dialect == DialectConfigParser {
+            err = d.parseConfigParserLine(tok, next, pstate)
+        } else {
+            err = parseLine(d, tok, next, pstate)
+        }
+        if err != nil {
+            return errorAt(d.file(), tok, err)
+        }
 
-        // Parse line
-        if err = parseLine(target, lineA, lineB, pstate); err != nil {
-            return
+        // Move to next line
+        if tok, err = lex.next(); err != nil {
+            return errorAt(d.file(), tok, err)
         }
+    }
 
-        // Move to next scan ahead line
-        lineA = lineB
+    // List may be last thing in the input
+    if err = closeList(d.target, pstate); err != nil {
+        return errorAt(d.file(), tok, err)
+    }
+    if len(d.files) == 1 {
+        d.unusedDefaults()
     }
     return
 }
 
-// Append consecutive lines until next 'k = v' or [section].
-func appendLines(scanner *bufio.Scanner, l1, l2 string) (lineA, lineB string, err error) {
-
-/*
-    fmt.Println()
-    fmt.Println("l1 = ", l1)
-    fmt.Println("l2 keyVal ?", isLikeKeyValue(l2), "=", l2)
-    fmt.Println("l2 section ?", isLikeSection(l2), "=", l2)
-    fmt.Println("l2 map ?", isLikeMap(l2), "=", l2)
-*/
+// Reads included file. Relative names are resolved against
+// directory of the including file. Included file starts
+// at root level; including file continues where it was.
+// Within file system absolute names start at its root.
+// Included file must not be outside of directory of top
+// level file, or outside of file system.
+func (d *decoder) include(name string) (err error) {
+    if !d.budget.opts.Includes {
+        return fmt.Errorf("error, includes are not enabled: %s", name)
+    }
+    if d.files[0] == "" {
+        return fmt.Errorf("error, include needs file input: %s", name)
+    }
 
-    // If next line is another value or section, return now
-    if isLikeKeyValue(l2) || isLikeSection(l2) || isLikeMap(l2) {
-        return l1, l2, nil
+    switch {
+    case d.fsys != nil && path.IsAbs(name):
+        name = path.Clean(name[1:])
+    case d.fsys != nil:
+        name = path.Join(path.Dir(d.file()), name)
+    case !filepath.IsAbs(name):
+        name = filepath.Join(filepath.Dir(d.file()), name)
+    default:
+        name = filepath.Clean(name)
+    }
+    if !d.contains(name) {
+        return fmt.Errorf("error, include outside of config directory: %s", name)
     }
 
-    lines := []string{l1, " ", l2}
-    for {
-        // Read next line to check if join ends there or not
-        if lineB, err = readNextLine(scanner); err != nil {
-            return
+    for _, f := range d.files {
+        if f == name {
+            return fmt.Errorf("error, include cycle: %s", name)
         }
+    }
+    if err = d.budget.include(len(d.files)); err != nil {
+        return
+    }
 
-/*
-    fmt.Println()
-    fmt.Println("l1 = ", strings.Join(lines, ""))
-    fmt.Println("lB keyVal ?", isLikeKeyValue(lineB), "=", lineB)
-    fmt.Println("lB section ?", isLikeSection(lineB), "=", lineB)
-    fmt.Println("lB map ?", isLikeMap(lineB), "=", lineB)
-*/
+    file, err := openFile(d.fsys, name)
+    if err != nil {
+        return fmt.Errorf("error reading include file: %s", name)
+    }
+    defer file.Close()
 
-        // Join ends if next line is:
-        // EOF
-        if lineB == "" {
-            break
-        }
-        // 'k = v'
-        if isLikeKeyValue(lineB) {
-            break
+    return d.parseInput(file, name)
+}
+
+// Is name within file system or directory of top level file?
+func (d *decoder) contains(name string) bool {
+    if d.fsys != nil {
+        return fs.ValidPath(name)
+    }
+    root, err := filepath.Abs(filepath.Dir(d.files[0]))
+    if err != nil {
+        return false
+    }
+    if name, err = filepath.Abs(name); err != nil {
+        return false
+    }
+    rel, err := filepath.Rel(root, name)
+    return err == nil && rel != ".." && !strings.HasPrefix(rel, ".." + string(filepath.Separator))
+}
+
+// Counts entry added to map against map size limit.
+func (d *decoder) mapEntry(name string) (err error) {
+    if err = d.budget.mapEntry(d.mapSizes[name]); err != nil {
+        return
+    }
+    d.mapSizes[name]++
+    return
+}
+
+// Append consecutive lines to token value
+// until next 'k = v' or [section].
+func appendLines(lex *lexer, tok *token, b *budget) (err error) {
+    lines := []string{tok.value}
+    length := len(tok.value)
+    for {
+        // Read next line to check if join ends there or not
+        next, err := continuation(lex)
+        if err != nil {
+            return err
         }
-        // like any [section]
-        if isLikeSection(lineB) {
+        if next == nil {
             break
         }
-        // like any [map.name]
-        if isLikeMap(lineB) {
-            break
+
+        // Append
+        length += 1 + len(next.raw)
+        if err = b.expansion(length); err != nil {
+            return err
         }
+        lines = append(lines, next.raw)
+        lex.skip()
+    }
 
-        // None of those, append
-        lines = append(lines, " ", lineB)
+    if len(lines) > 1 {
+        tok.value = strings.TrimLeft(strings.Join(lines, " "), " \t\n\f\r")
     }
-    return strings.Join(lines, ""), lineB, err
+    return
+}
+
+// Returns next line if it continues 'k += v', nil if not.
+func continuation(lex *lexer) (next *token, err error) {
+    if next, err = lex.peek(); err != nil {
+        return nil, err
+    }
+
+    // Join ends if next line is:
+    // EOF
+    if next.typ == tokEOF {
+        return nil, nil
+    }
+    // 'k = v'
+    if next.likeKeyValue {
+        return nil, nil
+    }
+    // like any [section] or [map.name]
+    if next.typ == tokSection || next.typ == tokMap || next.likeHeader {
+        return nil, nil
+    }
+    // @include
+    if next.typ == tokInclude {
+        return nil, nil
+    }
+    return next, nil
 }
 
 // Seek specified key.
-func seekInput(target *reflect.Value, r io.Reader, key string) (value string, err error) {
-    var lineA, lineB string
-	scanner := bufio.NewScanner(r)
+func seekInput(r io.Reader, key string) (value string, err error) {
+    lex := newLexer(r, newBudget(context.Background(), nil))
 
     // Read first line
-    if lineA, err = readNextLine(scanner); err != nil {
+    tok, err := lex.next()
+    if err != nil {
         return
     }
-    if lineA == "" {
+    if tok.typ == tokEOF {
 		return "", fmt.Errorf("error, file is empty")
     }
 
     // Read consecutive lines
-    for {
-        if lineA == "" {
-            break
-        }
-
-        if lineB, err = readNextLine(scanner); err != nil {
-            return
-        }
-
+    for tok.typ != tokEOF {
         // Check for key
-        if strings.HasPrefix(lineA, key) {
-            if _, value, ok := isKeyValue(lineA); ok {
-                return value, nil
+        if strings.HasPrefix(tok.raw, key) {
+            if tok.typ == tokKeyValue {
+                return tok.value, nil
             } else {
-                return "", fmt.Errorf("error, cannot parse: %s", value)
+                return "", errorAt("", tok, fmt.Errorf("error, cannot parse: %s", tok.raw))
             }
         }
 
-        // Move to next scan ahead line
-        lineA = lineB
-    }
-    return
-}
-
-// Reads next not empty line from provide scanner. 
-// Trims spaces and tabs from input line.
-// Returns empty line on EOF.
-// May return error if reading experienced one.
-func readNextLine(scanner *bufio.Scanner) (line string, err error) {
-    for {
-        hasMore := scanner.Scan()
-        line = strings.Trim(scanner.Text(), " \t")
-
-        // Can't read any more ?
-        if !hasMore {
-            return "", scanner.Err()
-        }
-
-        if line != "" {
+        // Move to next line
+        if tok, err = lex.next(); err != nil {
             return
         }
     }
+    return
 }
-
@@ -8,6 +8,7 @@ to target structure fields.
 import (
 	"fmt"
     "reflect"
+    "strconv"
 )
 
 //------------------------------------------------------------
@@ -27,6 +28,113 @@ func getElem(target interface{}) (elem reflect.Value, err error) {
     return
 }
 
+// Reflector writes input values into target element
+type reflector struct {
+    elem  reflect.Value
+    plan  *typePlan
+
+    // Normalizes input and field names for matching
+    names func(string) string
+
+    // Already normalized input names
+    norms map[string]string
+}
+
+// Creates reflector for target element using given options.
+func newReflector(elem reflect.Value, opts *Options) (r *reflector, err error) {
+    if opts == nil {
+        opts = &Options{}
+    }
+    names, err := opts.Names.normalizer(opts.NameFunc)
+    if err != nil {
+        return
+    }
+    if elem.Kind() != reflect.Struct {
+        return nil, fmt.Errorf("error, target must be pointer to struct, not: %v", elem.Type())
+    }
+
+    // Plans for custom normalizer cannot be shared
+    var plan *typePlan
+    if opts.Names == NamesFunc {
+        plan = newTypePlan(elem.Type(), names)
+    } else {
+        plan = cachedPlan(elem.Type(), opts.Names, names)
+    }
+    return &reflector{elem: elem, plan: plan, names: names, norms: map[string]string{}}, nil
+}
+
+// Finds field of struct element by input name using struct's plan.
+// Returns invalid value if no such field.
+func (r *reflector) lookup(elem reflect.Value, plan *typePlan, name string) (f reflect.Value, fp *fieldPlan, err error) {
+    if fp, err = plan.lookup(r.norm(name)); err != nil || fp == nil {
+        return
+    }
+    f, err = fieldByIndex(elem, fp.index)
+    return
+}
+
+// Gets nested field by index, allocating nil pointers
+// to embedded structs on the way.
+func fieldByIndex(elem reflect.Value, index []int) (f reflect.Value, err error) {
+    f = elem
+    for i, x := range index {
+        if i > 0 && f.Kind() == reflect.Ptr {
+            if f.IsNil() {
+                if !f.CanSet() {
+                    err = fmt.Errorf("error, cannot allocate embedded struct: %s", f.Type().Elem())
+                    return reflect.Value{}, err
+                }
+                f.Set(reflect.New(f.Type().Elem()))
+            }
+            f = f.Elem()
+        }
+        f = f.Field(x)
+    }
+    return
+}
+
+// Normalizes input name, remembering result.
+func (r *reflector) norm(name string) string {
+    norm, ok := r.norms[name]
+    if !ok {
+        norm = r.names(name)
+        r.norms[name] = norm
+    }
+    return norm
+}
+
+// Checks if field of key is tagged secret, or its
+// section or map is. Use empty key for map itself.
+func (r *reflector) isSecret(section, key string) bool {
+    name := key
+    if section != "" {
+        name = section
+    }
+    fp, _ := r.plan.lookup(r.norm(name))
+    if fp == nil || fp.secret || section == "" || key == "" {
+        return fp != nil && fp.secret
+    }
+
+    // Key of section
+    typ := r.elem.Type().FieldByIndex(fp.index).Type
+    if typ.Kind() == reflect.Ptr {
+        typ = typ.Elem()
+    }
+    if typ.Kind() != reflect.Struct {
+        return false
+    }
+    fp, _ = fp.nested(typ, r.plan).lookup(r.norm(key))
+    return fp != nil && fp.secret
+}
+
+// Hides value of secret field in error.
+func (r *reflector) redact(section, key, value string, err error) error {
+    if err == nil || value == "" || !r.isSecret(section, key) {
+        return err
+    }
+    return redactError(err, value)
+}
+
 //------------------------------------------------------------
 // Field getters
 //------------------------------------------------------------
@@ -50,175 +158,402 @@ func getField(target interface{}, name string) (value string, err error) {
 //------------------------------------------------------------
 
 // Sets plain field.
-func setField(elem *reflect.Value, section, key, value string) (err error) {
+func (r *reflector) setField(section, key, value string) (err error) {
     //fmt.Printf("\t[%s] SET FIELD: %s = %s\n", section, key, value)
 
-    section = toFieldName(section)
-    key = toFieldName(key)
+    field, err := r.findField(section, key)
+    if err != nil {
+        return err
+    }
+
+    if err = isFieldSettable(field, key); err != nil {
+        return err
+    }
+
+    return r.redact(section, key, value, setValue(*field, key, value))
+}
 
-    field, err := findField(elem, section, key)
+// Sets field to empty value of its kind: empty but not nil
+// slice for slices, empty string for strings.
+func (r *reflector) setEmpty(section, key string) (err error) {
+    field, err := r.findField(section, key)
     if err != nil {
         return err
     }
 
-    if err = isFieldModifiable(field, key, reflect.String); err != nil {
+    if err = isFieldSettable(field, key); err != nil {
         return err
     }
 
-    field.SetString(value)
+    f := indirect(*field)
+    switch f.Kind() {
+    case reflect.Slice:
+        f.Set(reflect.MakeSlice(f.Type(), 0, 0))
+
+    case reflect.Array:
+        if f.Len() != 0 {
+            return fmt.Errorf("error, array field: %s expects %v items, got 0", key, f.Len())
+        }
+
+    default:
+        return setValue(f, key, "")
+    }
     return
 }
 
-// Adds item to a slice.
-func addSliceItem(elem *reflect.Value, section, key, value string) (err error) {
-    //fmt.Printf("\tADD SLICE ITEM: [%s] %s %s\n", section, key, value)
+// Unsets field: sets it to zero value of its type,
+// so pointers, slices, maps and interfaces become nil.
+func (r *reflector) unsetField(section, key string) (err error) {
+    field, err := r.findField(section, key)
+    if err != nil {
+        return err
+    }
 
-    section = toFieldName(section)
-    key = toFieldName(key)
+    if err = isFieldSettable(field, key); err != nil {
+        return err
+    }
+
+    field.Set(reflect.Zero(field.Type()))
+    return
+}
 
-    field, err := findField(elem, section, key)
+// Adds item to a slice or array.
+// Index is the position of the item within its list.
+func (r *reflector) addSliceItem(section, key string, index int, value string) (err error) {
+    defer func() { err = r.redact(section, key, value, err) }()
+
+    //fmt.Printf("\tADD SLICE ITEM: [%s] %s %s\n", section, key, value)
+
+    field, err := r.findField(section, key)
     if err != nil {
         return err
     }
 
-    if err = isFieldModifiable(field, key, reflect.Slice); err != nil {
+    if err = isFieldSettable(field, key); err != nil {
         return
     }
 
-    // First on consecutive add ?
-    if field.IsNil() {
-        // First add
-        field.Set(reflect.MakeSlice(field.Type(), 1, 1))
-        field.Index(0).Set(//reflect.ValueOf(value))
-            reflect.ValueOf(value).Convert(field.Type().Elem()))
-    } else {
-        // Consecutive adds copy slice and increase its size by 1
-        l := field.Len()
-        fieldNew := reflect.MakeSlice(field.Type(), l + 1, l + 1)
-        for i := 0; i < l; i++ {
-            fieldNew.Index(i).Set(field.Index(i))
+    f := indirect(*field)
+    switch f.Kind() {
+    case reflect.Slice:
+        item := reflect.New(f.Type().Elem()).Elem()
+        if err = setValue(item, key, value); err != nil {
+            return
+        }
+
+        // Append grows capacity geometrically,
+        // so consecutive adds are amortized
+        f.Set(reflect.Append(f, item))
+
+    case reflect.Array:
+        if index >= f.Len() {
+            return fmt.Errorf("error, too many items for array field: %s, length is %v", key, f.Len())
+        }
+        return setValue(f.Index(index), key, value)
+
+    case reflect.Interface:
+        // Interface receives natural type: []string
+        list, ok := f.Interface().([]string)
+        if !f.IsNil() && !ok {
+            return fmt.Errorf("error, field already holds non list value: %s", key)
+        }
+        f.Set(reflect.ValueOf(append(list, value)))
+
+    default:
+        return fmt.Errorf("error, field must be slice or array: %s", key)
+    }
+
+    return
+}
+
+// Checks that array field received exactly as many items
+// as its length. Slices and other kinds are always okay.
+func (r *reflector) checkArrayLen(section, key string, count int) (err error) {
+    field, err := r.findField(section, key)
+    if err != nil {
+        return err
+    }
+
+    f := *field
+    if f.Kind() == reflect.Ptr {
+        if f.IsNil() {
+            return
         }
-        fieldNew.Index(l).Set(
-            reflect.ValueOf(value).Convert(field.Type().Elem()))
-        field.Set(fieldNew)
+        f = f.Elem()
     }
 
+    if f.Kind() == reflect.Array && count != f.Len() {
+        return fmt.Errorf("error, array field: %s expects %v items, got %v", key, f.Len(), count)
+    }
     return
 }
 
 // Adds item to a map. 
-func addMapItem(elem *reflect.Value, topmap, submap, key, value string) (err error) {
-    //fmt.Printf("\t\t    + ADD MAP ITEM: [%s | %s] : %s = %s\n", topmap, submap, key, value)
+func (r *reflector) addMapItem(topmap, submap, key, value string) (err error) {
+    defer func() { err = r.redact(topmap, "", value, err) }()
 
-    topmap = toFieldName(topmap)
+    //fmt.Printf("\t\t    + ADD MAP ITEM: [%s | %s] : %s = %s\n", topmap, submap, key, value)
 
-    field, err := findMap(elem, topmap)
+    field, err := r.findMap(topmap)
     if err != nil {
         return err
     }
 
-    if err = isFieldModifiable(field, topmap, reflect.Map); err != nil {
+    if err = isFieldSettable(field, topmap); err != nil {
+        return
+    }
+
+    f := indirect(*field)
+
+    // Interface receives natural type:
+    // map[string]string or map[string]map[string]string
+    if f.Kind() == reflect.Interface {
+        if f.IsNil() {
+            if submap == "" {
+                f.Set(reflect.ValueOf(map[string]string{}))
+            } else {
+                f.Set(reflect.ValueOf(map[string]map[string]string{}))
+            }
+        }
+        switch m := f.Interface().(type) {
+        case map[string]string:
+            if submap != "" {
+                return fmt.Errorf("error, map field already holds plain map: %s", topmap)
+            }
+            if value == nullValue {
+                delete(m, key)
+            } else {
+                m[key] = value
+            }
+        case map[string]map[string]string:
+            if submap == "" {
+                return fmt.Errorf("error, map field already holds map of maps: %s", topmap)
+            }
+            if m[submap] == nil {
+                m[submap] = map[string]string{}
+            }
+            if value == nullValue {
+                delete(m[submap], key)
+            } else {
+                m[submap][key] = value
+            }
+        default:
+            return fmt.Errorf("error, field already holds non map value: %s", topmap)
+        }
         return
     }
 
+    if f.Kind() != reflect.Map {
+        return fmt.Errorf("error, field must be map: %s", topmap)
+    }
+
     // First on consecutive add ?
-    if field.IsNil() {
-        field.Set(reflect.MakeMap(field.Type()))
+    if f.IsNil() {
+        f.Set(reflect.MakeMap(f.Type()))
     }
 
     // No submap ?
     if submap == "" {
-        field.SetMapIndex(reflect.ValueOf(key), 
-            reflect.ValueOf(value).Convert(field.Type().Elem()))
-        return
+        return setMapIndex(f, topmap, key, value)
+    }
+
+    if f.Type().Elem().Kind() != reflect.Map {
+        return fmt.Errorf("error, field must be map of maps: %s", topmap)
+    }
+    if f.Type().Key().Kind() != reflect.String {
+        return fmt.Errorf("error, map key must be string: %s", topmap)
     }
 
     // Lookup submap as a value in top map
-    subkeyval := reflect.ValueOf(submap)
-    subfield := field.MapIndex(subkeyval)
+    subkeyval := reflect.ValueOf(submap).Convert(f.Type().Key())
+    subfield := f.MapIndex(subkeyval)
 
     // First time add
     if ! subfield.IsValid() {
-        submapval := reflect.MakeMap(reflect.MapOf(
-            reflect.TypeOf(key), 
-            field.Type().Elem().Elem()))
+        subfield = reflect.MakeMap(f.Type().Elem())
+        f.SetMapIndex(subkeyval, subfield)
+    }
 
-        field.SetMapIndex(subkeyval, submapval)
-        submapval.SetMapIndex(reflect.ValueOf(key), 
-            reflect.ValueOf(value).Convert(submapval.Type().Elem()))
+    return setMapIndex(subfield, topmap, key, value)
+}
+
+// Sets map key to value converted to map's element type.
+// Null value removes key from map.
+func setMapIndex(m reflect.Value, name, key, value string) (err error) {
+    if m.Type().Key().Kind() != reflect.String {
+        return fmt.Errorf("error, map key must be string: %s", name)
+    }
+
+    if value == nullValue {
+        m.SetMapIndex(reflect.ValueOf(key).Convert(m.Type().Key()), reflect.Value{})
+        return
+    }
+
+    item := reflect.New(m.Type().Elem()).Elem()
+    if err = setValue(item, name, value); err != nil {
         return
     }
 
-    subfield.SetMapIndex(reflect.ValueOf(key), 
-            reflect.ValueOf(value).Convert(subfield.Type().Elem()))
+    m.SetMapIndex(reflect.ValueOf(key).Convert(m.Type().Key()), item)
+    return
+}
+
+// Sets value into field of any supported scalar kind.
+// Pointers are allocated as needed, interfaces receive string.
+func setValue(field reflect.Value, name, value string) (err error) {
+    switch field.Kind() {
+    case reflect.String:
+        field.SetString(value)
+
+    case reflect.Ptr:
+        if field.IsNil() {
+            field.Set(reflect.New(field.Type().Elem()))
+        }
+        return setValue(field.Elem(), name, value)
+
+    case reflect.Interface:
+        if field.NumMethod() != 0 {
+            return fmt.Errorf("error, not yet supported type for field: %s", name)
+        }
+        field.Set(reflect.ValueOf(value))
+
+    case reflect.Bool:
+        b, err := strconv.ParseBool(value)
+        if err != nil {
+            return fmt.Errorf("error, field must be bool: %s = %s", name, value)
+        }
+        field.SetBool(b)
+
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        i, err := strconv.ParseInt(value, 0, field.Type().Bits())
+        if err != nil {
+            return fmt.Errorf("error, field must be integer: %s = %s", name, value)
+        }
+        field.SetInt(i)
+
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        u, err := strconv.ParseUint(value, 0, field.Type().Bits())
+        if err != nil {
+            return fmt.Errorf("error, field must be unsigned integer: %s = %s", name, value)
+        }
+        field.SetUint(u)
+
+    case reflect.Float32, reflect.Float64:
+        f, err := strconv.ParseFloat(value, field.Type().Bits())
+        if err != nil {
+            return fmt.Errorf("error, field must be float: %s = %s", name, value)
+        }
+        field.SetFloat(f)
+
+    case reflect.Slice, reflect.Array:
+        return fmt.Errorf("error, field must be string: %s", name)
+
+    case reflect.Map:
+        return fmt.Errorf("error, field must be string, maps go into [map.*]: %s", name)
+
+    default:
+        return fmt.Errorf("error, not yet supported type for field: %s", name)
+    }
     return
 }
 
+// Follows pointers down to the pointed at value,
+// allocating nil pointers on the way.
+func indirect(field reflect.Value) reflect.Value {
+    for field.Kind() == reflect.Ptr {
+        if field.IsNil() {
+            field.Set(reflect.New(field.Type().Elem()))
+        }
+        field = field.Elem()
+    }
+    return field
+}
+
+// Allocates nested section struct pointer so that
+// a section present in input is never nil after parse.
+// Sections unknown to the struct are left for key lookup to report.
+func (r *reflector) touchSection(section string) {
+    f, _, err := r.lookup(r.elem, r.plan, section)
+    if err == nil && f.IsValid() && f.CanSet() && f.Kind() == reflect.Ptr &&
+        f.Type().Elem().Kind() == reflect.Struct {
+        indirect(f)
+    }
+}
+
+// Checks if section has field for key.
+func (r *reflector) hasField(section, key string) bool {
+    _, err := r.findField(section, key)
+    return err == nil
+}
+
 //------------------------------------------------------------
 // Field search functions
 //------------------------------------------------------------
 
 // Finds field by given section name and key.
 //
-func findField(elem *reflect.Value, section, key string) (field *reflect.Value, err error) {
+func (r *reflector) findField(section, key string) (field *reflect.Value, err error) {
     var f reflect.Value
+    var fp *fieldPlan
     if section == "" {
         // Get root section element
-        f = elem.FieldByName(key)
+        if f, _, err = r.lookup(r.elem, r.plan, key); err != nil {
+            return
+        }
         if !f.IsValid() {
-            err = fmt.Errorf("struct doesn't have field: %s", key)
+            err = unknownKey("struct doesn't have field: %s", key)
         }
     } else {
         // Get inner struct element
-        f = elem.FieldByName(section)
+        if f, fp, err = r.lookup(r.elem, r.plan, section); err != nil {
+            return
+        }
         if !f.IsValid() {
-            err = fmt.Errorf("struct doesn't have nested struct: %s", section)
+            err = unknownKey("struct doesn't have nested struct: %s", section)
+            return
+        }
+        // Nested struct pointers are allocated lazily
+        if f.Kind() == reflect.Ptr && f.Type().Elem().Kind() == reflect.Struct {
+            if !f.CanSet() {
+                err = fmt.Errorf("error, nested struct cannot be set: %s", section)
+                return
+            }
+            f = indirect(f)
+        }
+        if f.Kind() != reflect.Struct {
+            err = fmt.Errorf("struct field is not a nested struct: %s", section)
+            return
+        }
+        if f, _, err = r.lookup(f, fp.nested(f.Type(), r.plan), key); err != nil {
             return
         }
-        f = f.FieldByName(key)
         if !f.IsValid() {
-            err = fmt.Errorf("struct doesn't have nested struct field: %s.%s", section, key)
+            err = unknownKey("struct doesn't have nested struct field: %s.%s", section, key)
         }
     }
     return &f, err
 }
 
 // Finds map field that can be inside another map.
-func findMap(elem *reflect.Value, name string) (field *reflect.Value, err error) {
-    var f reflect.Value
-    
-    f = elem.FieldByName(name)
+func (r *reflector) findMap(name string) (field *reflect.Value, err error) {
+    f, _, err := r.lookup(r.elem, r.plan, name)
+    if err != nil {
+        return
+    }
     if !f.IsValid() {
-        err = fmt.Errorf("struct doesn't have map field: %s", name)
+        err = unknownKey("struct doesn't have map field: %s", name)
     }
     return &f, err
 }
 
-// Checks if field can be modified and 
-// field kind matches give kind.
-func isFieldModifiable(field *reflect.Value, name string, kind reflect.Kind) (err error) {
+// Checks if field can be modified.
+// Kind compatibility is checked by setters themselves.
+func isFieldSettable(field *reflect.Value, name string) (err error) {
     if !field.IsValid() {
         return fmt.Errorf("error, field not valid: %s", name)
     }
     if !field.CanSet() {
         return fmt.Errorf("error, field cannot be set: %s", name)
     }
-
-    if field.Kind() != kind {
-        switch kind {
-        case reflect.String:
-            return fmt.Errorf("error, field must be string: %s", name)
-
-        case reflect.Slice:
-            return fmt.Errorf("error, field must be slice: %s", name)
-
-        case reflect.Map:
-            return fmt.Errorf("error, field must be map: %s", name)
-
-        default:
-            return fmt.Errorf("error, not yet supported type for field: %s", name)
-        }
-    }
     return
 }
-
@@ -0,0 +1,610 @@
+package skini
+
+/*
+Schema -- JSON Schema of input, generated from struct and
+used to validate documents without the struct.
+
+Schema describes the tree of converters, see convert.go:
+  key = value          "key": {"type": "integer"}
+  key = list...        "key": {"type": "array", "items": ...}
+  [section]            "section": {"type": "object", ...}
+  [map.name]           "map.name": {"type": "object",
+                           "additionalProperties": ...}
+
+Values are strings in input, types say how they must read.
+Field tags add constraints:
+  Port int    `desc:"Listen port" min:"1" max:"65535"`
+  Mode string `enum:"debug,release" skini:",required"`
+  Id   string `pattern:"^[a-z]+$"`
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Version of JSON Schema written
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// Subset of JSON Schema that describes input
+type Schema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// object, array, string, integer, number or boolean.
+	// Empty allows any value.
+	Type string `json:"type,omitempty"`
+
+	// Object
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+
+	// Schema of keys not in properties, nil allows any
+	AdditionalProperties *Schema `json:"-"`
+
+	// No keys besides properties allowed
+	Closed bool `json:"-"`
+
+	// Array
+	Items    *Schema `json:"items,omitempty"`
+	MinItems *int    `json:"minItems,omitempty"`
+	MaxItems *int    `json:"maxItems,omitempty"`
+
+	// Scalars
+	Enum      []string    `json:"enum,omitempty"`
+	Pattern   string      `json:"pattern,omitempty"`
+	Minimum   *float64    `json:"minimum,omitempty"`
+	Maximum   *float64    `json:"maximum,omitempty"`
+	Default   interface{} `json:"default,omitempty"`
+	WriteOnly bool        `json:"writeOnly,omitempty"`
+
+	// Pattern compiled on first check
+	compile sync.Once
+	pattern *regexp.Regexp
+}
+
+// Schema as written, additionalProperties is false or schema
+type schemaJSON Schema
+
+type schemaWithAdditional struct {
+	*schemaJSON
+	AdditionalProperties json.RawMessage `json:"additionalProperties,omitempty"`
+}
+
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	out := schemaWithAdditional{schemaJSON: (*schemaJSON)(s)}
+	switch {
+	case s.Closed:
+		out.AdditionalProperties = json.RawMessage("false")
+	case s.AdditionalProperties != nil:
+		data, err := json.Marshal(s.AdditionalProperties)
+		if err != nil {
+			return nil, err
+		}
+		out.AdditionalProperties = data
+	}
+	return json.Marshal(out)
+}
+
+func (s *Schema) UnmarshalJSON(data []byte) (err error) {
+	in := schemaWithAdditional{schemaJSON: (*schemaJSON)(s)}
+	if err = json.Unmarshal(data, &in); err != nil {
+		return
+	}
+	switch strings.TrimSpace(string(in.AdditionalProperties)) {
+	case "", "true":
+	case "false":
+		s.Closed = true
+	default:
+		s.AdditionalProperties = &Schema{}
+		err = json.Unmarshal(in.AdditionalProperties, s.AdditionalProperties)
+	}
+	return
+}
+
+// Reads JSON Schema.
+func ReadSchema(data []byte) (s *Schema, err error) {
+	s = &Schema{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("error reading schema: %w", err)
+	}
+	return
+}
+
+//------------------------------------------------------------
+// Generation
+//------------------------------------------------------------
+
+// Writes JSON Schema of struct, pointer to struct or
+// its reflect.Type. Current values of fields are defaults.
+func JSONSchema(target interface{}) ([]byte, error) {
+	s, err := SchemaOf(target)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// Builds schema of struct, pointer to struct or its reflect.Type.
+func SchemaOf(target interface{}) (s *Schema, err error) {
+	var elem reflect.Value
+	if typ, ok := target.(reflect.Type); ok {
+		elem = reflect.New(typ).Elem()
+	} else {
+		elem = reflect.ValueOf(target)
+	}
+	elem = templateElem(elem)
+	if elem.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("error, can only build schema of struct, got: %v", elem.Kind())
+	}
+
+	s = &Schema{Schema: schemaDraft, Title: elem.Type().Name()}
+	if err = s.object(elem, true, false); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Fills closed object schema with fields of root or section.
+func (s *Schema) object(elem reflect.Value, root, secret bool) (err error) {
+	s.Type, s.Closed = "object", true
+	if s.Properties == nil {
+		s.Properties = map[string]*Schema{}
+	}
+
+	typ := elem.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		field := elem.Field(i)
+
+		if sf.Anonymous && sf.Tag.Get("skini") == "" {
+			if f := templateElem(field); f.Kind() == reflect.Struct {
+				if err = s.object(f, root, secret); err != nil {
+					return
+				}
+			}
+			continue
+		}
+
+		name := fieldInputName(sf)
+		if name == "" {
+			continue
+		}
+		if name == sf.Name {
+			name = lowerFirst(name)
+		}
+
+		f := templateElem(field)
+		prop := &Schema{Description: sf.Tag.Get("desc")}
+		isSecret := secret || hasTagOption(sf, "secret")
+
+		switch f.Kind() {
+		case reflect.Struct:
+			if !root {
+				return fmt.Errorf("error, sections cannot nest: %s", name)
+			}
+			err = prop.object(f, false, isSecret)
+		case reflect.Map:
+			if !root {
+				return fmt.Errorf("error, maps must be at root: %s", name)
+			}
+			name = "map." + name
+			err = prop.mapOf(f.Type(), isSecret)
+		default:
+			err = prop.field(sf, f, isSecret)
+		}
+		if err != nil {
+			return
+		}
+
+		s.Properties[name] = prop
+		if hasTagOption(sf, "required") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return
+}
+
+// Fills schema of map, values of map of maps are maps.
+func (s *Schema) mapOf(typ reflect.Type, secret bool) error {
+	if typ.Key().Kind() != reflect.String {
+		return fmt.Errorf("error, map keys must be strings: %v", typ)
+	}
+	s.Type = "object"
+	s.AdditionalProperties = &Schema{WriteOnly: secret}
+
+	elem := typ.Elem()
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() == reflect.Map {
+		return s.AdditionalProperties.mapOf(elem, secret)
+	}
+	return s.AdditionalProperties.scalar(elem)
+}
+
+// Fills schema of scalar or list field with type,
+// constraints of tags and default.
+func (s *Schema) field(sf reflect.StructField, f reflect.Value, secret bool) (err error) {
+	target := s
+	switch f.Kind() {
+	case reflect.Slice, reflect.Array:
+		s.Type, s.Items = "array", &Schema{}
+		if f.Kind() == reflect.Array {
+			n := f.Len()
+			s.MinItems, s.MaxItems = &n, &n
+		}
+		target = s.Items
+		err = target.scalar(f.Type().Elem())
+	default:
+		err = s.scalar(f.Type())
+	}
+	if err != nil {
+		return
+	}
+
+	if enum := sf.Tag.Get("enum"); enum != "" {
+		target.Enum = strings.Split(enum, ",")
+	}
+	if pattern := sf.Tag.Get("pattern"); pattern != "" {
+		if _, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("error, invalid pattern of field: %s", sf.Name)
+		}
+		target.Pattern = pattern
+	}
+	min, err := tagFloat(sf, "min")
+	if err != nil {
+		return
+	}
+	max, err := tagFloat(sf, "max")
+	if err != nil {
+		return
+	}
+	if min != nil {
+		target.Minimum = min
+	}
+	if max != nil {
+		target.Maximum = max
+	}
+
+	s.WriteOnly = secret
+	if !secret && !f.IsZero() {
+		s.Default = schemaDefault(f)
+	}
+	return
+}
+
+// Sets type of scalar kind.
+func (s *Schema) scalar(typ reflect.Type) error {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		s.Type = "string"
+	case reflect.Interface:
+	case reflect.Bool:
+		s.Type = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s.Type = "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s.Type = "integer"
+		zero := 0.0
+		s.Minimum = &zero
+	case reflect.Float32, reflect.Float64:
+		s.Type = "number"
+	default:
+		return fmt.Errorf("error, not yet supported type: %v", typ)
+	}
+	return nil
+}
+
+// Gets numeric tag, nil if not present.
+func tagFloat(sf reflect.StructField, name string) (*float64, error) {
+	tag := sf.Tag.Get(name)
+	if tag == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(tag, 64)
+	if err != nil {
+		return nil, fmt.Errorf("error, tag %s of field %s must be number: %s", name, sf.Name, tag)
+	}
+	return &f, nil
+}
+
+// Default of scalar or list as JSON value.
+func schemaDefault(f reflect.Value) interface{} {
+	f = templateElem(f)
+	switch f.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]interface{}, f.Len())
+		for i := range items {
+			items[i] = schemaDefault(f.Index(i))
+		}
+		return items
+	case reflect.Bool:
+		return f.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		s, _ := formatField(f, "", false)
+		return json.Number(s)
+	}
+	s, _ := formatField(f, "", false)
+	return s
+}
+
+//------------------------------------------------------------
+// Validation
+//------------------------------------------------------------
+
+// Validation error lists every problem found, in order,
+// positioned at line and column of key or header
+type ValidationError struct {
+	Errors []*ParseError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Validator collects errors of document
+type validator struct {
+	file   string
+	errors []*ParseError
+}
+
+func (v *validator) add(line, col int, format string, args ...interface{}) {
+	v.errors = append(v.errors, &ParseError{File: v.file, Line: line, Col: col, Err: fmt.Errorf(format, args...)})
+}
+
+// Checks document against schema. Names are matched as
+// parser does by default: server.http matches serverHttp.
+// Includes are not followed. File names errors, it can be
+// empty. Returns *ValidationError listing all problems.
+func (s *Schema) Validate(doc *Document, file string) error {
+	v := &validator{file: file}
+
+	// Keys seen in each object present, in order of first
+	// appearance, with block where object starts
+	seen := map[*Schema]map[string]bool{}
+	var objects []*Schema
+	starts := map[*Schema]*Block{}
+	mark := func(obj *Schema, name string, start *Block) {
+		if seen[obj] == nil {
+			seen[obj] = map[string]bool{}
+			objects = append(objects, obj)
+			starts[obj] = start
+		}
+		seen[obj][name] = true
+	}
+	mark(s, "", nil)
+
+	for _, b := range doc.Blocks {
+		obj := s
+		switch b.Kind {
+		case BlockSection:
+			name, prop := s.property(b.Name)
+			switch {
+			case prop == nil:
+				v.add(b.Line, b.Col, "unknown section: %s", b.Name)
+				continue
+			case prop.Type != "object" || !prop.Closed:
+				v.add(b.Line, b.Col, "%s is not a section", b.Name)
+				continue
+			}
+			mark(s, name, nil)
+			mark(prop, "", b)
+			obj = prop
+
+		case BlockMap:
+			name, prop := s.property("map." + b.Name)
+			switch {
+			case prop == nil:
+				v.add(b.Line, b.Col, "unknown map: %s", b.Name)
+				continue
+			case prop.Type != "object" || prop.Closed:
+				v.add(b.Line, b.Col, "%s is not a map", b.Name)
+				continue
+			}
+			mark(s, name, nil)
+			obj = prop
+			if b.Key != "" {
+				if obj = prop.AdditionalProperties; obj == nil || obj.Type != "object" {
+					v.add(b.Line, b.Col, "map %s has no submaps", b.Name)
+					continue
+				}
+			}
+		}
+
+		for _, e := range b.Entries {
+			if e.Kind == EntryInclude {
+				continue
+			}
+			name, prop := obj.property(e.Key)
+			if prop == nil {
+				v.add(e.Line, e.Col, "unknown key: %s", e.Key)
+				continue
+			}
+			mark(obj, name, nil)
+			v.entry(e, prop)
+		}
+	}
+
+	// Required keys of objects present
+	for _, obj := range objects {
+		for _, name := range obj.Required {
+			if !seen[obj][name] {
+				line, col := 0, 0
+				if b := starts[obj]; b != nil {
+					line, col = b.Line, b.Col
+				}
+				v.add(line, col, "missing required key: %s", name)
+			}
+		}
+	}
+
+	if len(v.errors) > 0 {
+		return &ValidationError{v.errors}
+	}
+	return nil
+}
+
+// Gets schema of key, [section] or [map.name] by input name,
+// nil if object doesn't allow it.
+func (s *Schema) Property(name string) *Schema {
+	_, prop := s.property(name)
+	return prop
+}
+
+// Finds property of object by input name. Closed objects
+// only have properties, others take any key. Of properties
+// spelled differently the first in sorted order matches.
+func (s *Schema) property(name string) (string, *Schema) {
+	if prop := s.Properties[name]; prop != nil {
+		return name, prop
+	}
+	norm, found := schemaName(name), ""
+	for key := range s.Properties {
+		if schemaName(key) == norm && (found == "" || key < found) {
+			found = key
+		}
+	}
+	if found != "" {
+		return found, s.Properties[found]
+	}
+	if !s.Closed && s.AdditionalProperties != nil {
+		return name, s.AdditionalProperties
+	}
+	if !s.Closed {
+		return name, &Schema{}
+	}
+	return name, nil
+}
+
+// Normalizes property name as parser matches it.
+func schemaName(name string) string {
+	if strings.HasPrefix(name, "map.") {
+		return "map." + toFieldName(name[len("map."):])
+	}
+	return toFieldName(name)
+}
+
+// Checks entry against its schema.
+func (v *validator) entry(e *Entry, s *Schema) {
+	if s.Type == "object" {
+		v.add(e.Line, e.Col, "%s must be a section or map", e.Key)
+		return
+	}
+	if e.IsNull() {
+		return
+	}
+
+	if s.Type != "array" {
+		if e.Kind == EntryList {
+			v.add(e.Line, e.Col, "%s must be a single value", e.Key)
+			return
+		}
+		if err := s.check(e.Value); err != nil {
+			v.add(e.Line, e.Col, "%s: %s", e.Key, err)
+		}
+		return
+	}
+
+	// Empty value is empty list
+	items := e.Items
+	if e.Kind == EntryValue && e.Value != "" {
+		v.add(e.Line, e.Col, "%s must be a list", e.Key)
+		return
+	}
+	if s.MinItems != nil && len(items) < *s.MinItems || s.MaxItems != nil && len(items) > *s.MaxItems {
+		v.add(e.Line, e.Col, "%s has %v items, %s", e.Key, len(items), s.itemsRange())
+	}
+	if s.Items == nil {
+		return
+	}
+	for _, item := range items {
+		if err := s.Items.check(item); err != nil {
+			v.add(e.Line, e.Col, "%s: %s", e.Key, err)
+		}
+	}
+}
+
+// Describes allowed number of items.
+func (s *Schema) itemsRange() string {
+	switch {
+	case s.MinItems != nil && s.MaxItems != nil && *s.MinItems == *s.MaxItems:
+		return fmt.Sprintf("expected %v", *s.MinItems)
+	case s.MaxItems == nil:
+		return fmt.Sprintf("expected at least %v", *s.MinItems)
+	case s.MinItems == nil:
+		return fmt.Sprintf("expected at most %v", *s.MaxItems)
+	}
+	return fmt.Sprintf("expected %v to %v", *s.MinItems, *s.MaxItems)
+}
+
+// Checks scalar value as it reads into type of schema.
+func (s *Schema) check(value string) error {
+	var num float64
+	var err error
+	switch s.Type {
+	case "boolean":
+		if _, err = strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("must be boolean: %s", value)
+		}
+	case "integer":
+		var i int64
+		if i, err = strconv.ParseInt(value, 0, 64); err != nil {
+			var u uint64
+			if u, err = strconv.ParseUint(value, 0, 64); err != nil {
+				return fmt.Errorf("must be integer: %s", value)
+			}
+			num = float64(u)
+		} else {
+			num = float64(i)
+		}
+	case "number":
+		if num, err = strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("must be number: %s", value)
+		}
+	case "array", "object":
+		return errors.New("must not be a single value")
+	}
+
+	if numeric := s.Type == "integer" || s.Type == "number"; numeric &&
+		(s.Minimum != nil && num < *s.Minimum || s.Maximum != nil && num > *s.Maximum) {
+		return fmt.Errorf("out of range: %s", value)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			found = found || allowed == value
+		}
+		if !found {
+			return fmt.Errorf("must be one of %s: %s", strings.Join(s.Enum, ", "), value)
+		}
+	}
+	if s.Pattern != "" {
+		s.compile.Do(func() {
+			s.pattern, _ = regexp.Compile(s.Pattern)
+		})
+		if s.pattern == nil {
+			return fmt.Errorf("invalid pattern in schema: %s", s.Pattern)
+		}
+		if !s.pattern.MatchString(value) {
+			return fmt.Errorf("must match %s: %s", s.Pattern, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package skini
+
+/*
+Schemaless -- decodes input into map[string]interface{}
+for consumers that define their own shape, and decodes
+such maps into structs with the regular Reflector.
+
+Shape of the map:
+  key = value          m["key"] = "value"
+  key = list...        m["key"] = []string{...}
+  [section] key = v    m["section"]["key"] = "v"
+  [map.name] k = v     m["name"]["k"] = "v"
+  [map.name | sub]     m["name"]["sub"]["k"] = "v"
+*/
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type of schemaless target
+var schemalessType = reflect.TypeOf(map[string]interface{}{})
+
+//------------------------------------------------------------
+// Map receiver
+//------------------------------------------------------------
+
+// Receives parsed values into map[string]interface{}.
+type mapReceiver struct {
+	m     map[string]interface{}
+	infer bool
+}
+
+// Creates receiver filling map element, allocating it if nil.
+func newMapReceiver(elem reflect.Value, opts *Options) *mapReceiver {
+	if elem.IsNil() {
+		elem.Set(reflect.MakeMap(schemalessType))
+	}
+	r := &mapReceiver{m: elem.Interface().(map[string]interface{})}
+	if opts != nil {
+		r.infer = opts.InferTypes
+	}
+	return r
+}
+
+// Gets nested map by name, creating it on first use.
+// Root level map is returned for empty name.
+func (r *mapReceiver) scope(parent map[string]interface{}, name string) (m map[string]interface{}, err error) {
+	if name == "" {
+		return parent, nil
+	}
+	switch v := parent[name].(type) {
+	case nil:
+		m = map[string]interface{}{}
+		parent[name] = m
+	case map[string]interface{}:
+		m = v
+	default:
+		err = fmt.Errorf("error, key already holds non map value: %s", name)
+	}
+	return
+}
+
+func (r *mapReceiver) touchSection(section string) {
+	r.scope(r.m, section)
+}
+
+func (r *mapReceiver) hasField(section, key string) bool {
+	return true
+}
+
+func (r *mapReceiver) isSecret(section, key string) bool {
+	return false
+}
+
+func (r *mapReceiver) setField(section, key, value string) (err error) {
+	m, err := r.scope(r.m, section)
+	if err == nil {
+		m[key] = r.value(value)
+	}
+	return
+}
+
+func (r *mapReceiver) setEmpty(section, key string) (err error) {
+	m, err := r.scope(r.m, section)
+	if err == nil {
+		m[key] = ""
+	}
+	return
+}
+
+func (r *mapReceiver) unsetField(section, key string) (err error) {
+	m, err := r.scope(r.m, section)
+	if err == nil {
+		delete(m, key)
+	}
+	return
+}
+
+// List starts anew with its first item.
+func (r *mapReceiver) addSliceItem(section, key string, index int, value string) (err error) {
+	m, err := r.scope(r.m, section)
+	if err != nil {
+		return
+	}
+
+	if r.infer {
+		list, _ := m[key].([]interface{})
+		if index == 0 {
+			list = nil
+		}
+		m[key] = append(list, r.value(value))
+	} else {
+		list, _ := m[key].([]string)
+		if index == 0 {
+			list = nil
+		}
+		m[key] = append(list, value)
+	}
+	return
+}
+
+func (r *mapReceiver) checkArrayLen(section, key string, count int) error {
+	return nil
+}
+
+func (r *mapReceiver) addMapItem(topmap, submap, key, value string) (err error) {
+	m, err := r.scope(r.m, topmap)
+	if err != nil {
+		return
+	}
+	if m, err = r.scope(m, submap); err != nil {
+		return
+	}
+
+	if value == nullValue {
+		delete(m, key)
+	} else {
+		m[key] = r.value(value)
+	}
+	return
+}
+
+// Converts value into int, float or bool if asked to infer types
+// and value is written in canonical form. Otherwise keeps string.
+func (r *mapReceiver) value(s string) interface{} {
+	if !r.infer || s == "" {
+		return s
+	}
+
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if i, err := strconv.Atoi(s); err == nil && strconv.Itoa(i) == s {
+		return i
+	}
+
+	// Floats must start like numbers, so that Inf and NaN stay
+	// strings, and must format back to input, so that 007, +5,
+	// 1.10 and ints too big for int stay strings
+	digits := strings.TrimLeft(s, "+-")
+	if digits == "" {
+		return s
+	}
+	if c := digits[0]; c == '.' || '0' <= c && c <= '9' {
+		if f, err := strconv.ParseFloat(s, 64); err == nil && strconv.FormatFloat(f, 'g', -1, 64) == s {
+			return f
+		}
+	}
+	return s
+}
+
+//------------------------------------------------------------
+// Map to struct decoding
+//------------------------------------------------------------
+
+// Decodes map produced by parsing into map[string]interface{}
+// into target struct, exactly as if input was parsed into it.
+// Nested maps go to sections or [map.*] fields depending on
+// target field's kind. Keys are decoded in sorted order.
+func Decode(target interface{}, m map[string]interface{}, opts *Options) (err error) {
+	elem, err := getElem(target)
+	if err != nil {
+		return
+	}
+	refl, err := newReflector(elem, opts)
+	if err != nil {
+		return
+	}
+
+	for _, key := range sortedKeys(m) {
+		switch v := m[key].(type) {
+		case map[string]interface{}:
+			err = decodeNested(refl, key, v)
+		default:
+			err = decodeValue(refl, "", key, v)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err)
+		}
+	}
+	return
+}
+
+// Decodes nested map into section or [map.*] field.
+func decodeNested(refl *reflector, name string, m map[string]interface{}) (err error) {
+	field, err := refl.findMap(name)
+	if err != nil {
+		return
+	}
+
+	// Field kind tells section from map
+	kind := field.Kind()
+	if kind == reflect.Ptr {
+		kind = field.Type().Elem().Kind()
+	}
+	if kind == reflect.Struct {
+		refl.touchSection(name)
+		for _, key := range sortedKeys(m) {
+			if err = decodeValue(refl, name, key, m[key]); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	for _, key := range sortedKeys(m) {
+		switch v := m[key].(type) {
+		case map[string]interface{}:
+			// Submap
+			for _, subkey := range sortedKeys(v) {
+				if err = refl.addMapItem(name, key, subkey, formatValue(v[subkey])); err != nil {
+					return
+				}
+			}
+		default:
+			if err = refl.addMapItem(name, "", key, formatValue(v)); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Decodes single value or list into field of section.
+func decodeValue(refl *reflector, section, key string, v interface{}) (err error) {
+	var list []string
+	switch v := v.(type) {
+	case []string:
+		list = v
+	case []interface{}:
+		for _, item := range v {
+			list = append(list, formatValue(item))
+		}
+	case map[string]interface{}:
+		return fmt.Errorf("error, sections cannot be nested: %s", key)
+	default:
+		if s := formatValue(v); s == "" {
+			return refl.setEmpty(section, key)
+		} else {
+			return refl.setField(section, key, s)
+		}
+	}
+
+	if len(list) == 0 {
+		return refl.setEmpty(section, key)
+	}
+	for i, item := range list {
+		if err = refl.addSliceItem(section, key, i, item); err != nil {
+			return
+		}
+	}
+	return refl.checkArrayLen(section, key, len(list))
+}
+
+// Formats inferred value back as it was written.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// Gets map keys in sorted order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,219 @@
+package skini
+
+/*
+Secrets -- secret fields and value references.
+
+Fields tagged `skini:",secret"` are redacted by Marshal,
+String, Meta and errors. Values can refer to secrets kept
+elsewhere, resolved at decode time by Options.Secrets:
+  password = @file:/run/secrets/db
+  password = @env:DB_PASS
+  password = @vault:db/password     with custom resolver
+  literal = @@env:not a reference   reads as @env:not a reference
+
+Without resolver values are kept as written, @@ included.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Text shown instead of secret values
+const Redacted = "********"
+
+//------------------------------------------------------------
+// Resolvers
+//------------------------------------------------------------
+
+// Resolver returns it for schemes it doesn't handle,
+// value is then kept as written.
+var ErrNotReference = errors.New("error, not a reference")
+
+// Secret resolver supplies values of references @scheme:ref.
+// Scheme is lowercase letters between @ and first colon.
+type SecretResolver interface {
+	Resolve(ctx context.Context, scheme, ref string) (string, error)
+}
+
+// Function implementing SecretResolver
+type SecretResolverFunc func(ctx context.Context, scheme, ref string) (string, error)
+
+func (f SecretResolverFunc) Resolve(ctx context.Context, scheme, ref string) (string, error) {
+	return f(ctx, scheme, ref)
+}
+
+// Resolves @file:path to contents of file less trailing
+// newlines and @env:NAME to environment variable. Reads
+// any file and variable input names, so set it in
+// Options.Secrets only for trusted input.
+var DefaultSecrets SecretResolver = SecretResolverFunc(resolveDefault)
+
+// Keeps all references as written, only reads escaped @@
+// as @. Set it to read back Marshal output.
+var NoSecrets SecretResolver = SecretResolverFunc(func(ctx context.Context, scheme, ref string) (string, error) {
+	return "", ErrNotReference
+})
+
+func resolveDefault(ctx context.Context, scheme, ref string) (string, error) {
+	switch scheme {
+	case "file":
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("error reading secret file: %s", ref)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "env":
+		value, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("error, environment variable not set: %s", ref)
+		}
+		return value, nil
+	}
+	return "", ErrNotReference
+}
+
+// Splits reference @scheme:ref.
+func splitReference(value string) (scheme, ref string, ok bool) {
+	if len(value) < 3 || value[0] != '@' {
+		return
+	}
+	i := 1
+	for i < len(value) && 'a' <= value[i] && value[i] <= 'z' {
+		i++
+	}
+	if i == 1 || i == len(value) || value[i] != ':' || i+1 == len(value) {
+		return
+	}
+	return value[1:i], value[i+1:], true
+}
+
+// Does value need escaping to be read back as written ?
+func isReferenceLike(value string) bool {
+	_, _, ok := splitReference(value)
+	return ok || value == nullValue || strings.HasPrefix(value, "@@")
+}
+
+//------------------------------------------------------------
+// Resolving receiver
+//------------------------------------------------------------
+
+// Receiver resolving references before passing values on.
+type resolver struct {
+	next    receiver
+	ctx     context.Context
+	secrets SecretResolver
+}
+
+// Resolves value if it is a reference. Escaped @@ loses one @.
+func (r *resolver) resolve(value string) (string, error) {
+	if value == "" || value[0] != '@' {
+		return value, nil
+	}
+	if strings.HasPrefix(value, "@@") {
+		return value[1:], nil
+	}
+	scheme, ref, ok := splitReference(value)
+	if !ok {
+		return value, nil
+	}
+
+	resolved, err := r.secrets.Resolve(r.ctx, scheme, ref)
+	if errors.Is(err, ErrNotReference) {
+		return value, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error resolving %s: %w", value, err)
+	}
+	return resolved, nil
+}
+
+// Replaces resolved value in error by reference.
+func hideResolved(err error, value, resolved string) error {
+	if err == nil || value == resolved || resolved == "" {
+		return err
+	}
+	return &redactedError{strings.ReplaceAll(err.Error(), resolved, value), err}
+}
+
+func (r *resolver) touchSection(section string) {
+	r.next.touchSection(section)
+}
+
+func (r *resolver) hasField(section, key string) bool {
+	return r.next.hasField(section, key)
+}
+
+func (r *resolver) isSecret(section, key string) bool {
+	return r.next.isSecret(section, key)
+}
+
+func (r *resolver) setField(section, key, value string) error {
+	resolved, err := r.resolve(value)
+	if err != nil {
+		return err
+	}
+	return hideResolved(r.next.setField(section, key, resolved), value, resolved)
+}
+
+func (r *resolver) setEmpty(section, key string) error {
+	return r.next.setEmpty(section, key)
+}
+
+func (r *resolver) unsetField(section, key string) error {
+	return r.next.unsetField(section, key)
+}
+
+func (r *resolver) addSliceItem(section, key string, index int, value string) error {
+	resolved, err := r.resolve(value)
+	if err != nil {
+		return err
+	}
+	return hideResolved(r.next.addSliceItem(section, key, index, resolved), value, resolved)
+}
+
+func (r *resolver) checkArrayLen(section, key string, count int) error {
+	return r.next.checkArrayLen(section, key, count)
+}
+
+func (r *resolver) addMapItem(topmap, submap, key, value string) error {
+	resolved, err := r.resolve(value)
+	if err != nil {
+		return err
+	}
+	return hideResolved(r.next.addMapItem(topmap, submap, key, resolved), value, resolved)
+}
+
+//------------------------------------------------------------
+// Redaction
+//------------------------------------------------------------
+
+// Error with secret hidden from its message
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Hides secret value in error message.
+func redactError(err error, value string) error {
+	return &redactedError{strings.ReplaceAll(err.Error(), value, Redacted), err}
+}
+
+// Hides value of key in raw line: key = ********
+func redactRaw(raw string) string {
+	if i := strings.IndexAny(raw, "=:"); i >= 0 {
+		return raw[:i+1] + " " + Redacted
+	}
+	return Redacted
+}
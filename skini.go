@@ -6,71 +6,197 @@ Skini -- parser of improved ini files
 */
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"os"
+	"io/fs"
 	"path"
 )
 
+// Parsing options. Zero value gives default behavior.
+type Options struct {
+	// Strategy for matching input names to struct fields
+	Names NameMatch
+
+	// Custom name normalizer used with NamesFunc strategy
+	NameFunc func(string) string
+
+	// Syntax of input, improved INI by default
+	Dialect Dialect
+
+	// Skip keys that have no matching struct field
+	// instead of failing. Skipped keys are listed
+	// as unused in Meta.
+	IgnoreUnknown bool
+
+	// Records origin of every value when set
+	Meta *Meta
+
+	// Resolves @scheme:ref references in values and reads
+	// escaped @@ as @. Nil keeps all values as written, set
+	// DefaultSecrets to read @file: and @env: references or
+	// NoSecrets to only unescape, as Marshal output needs.
+	Secrets SecretResolver
+
+	// Infer int, float and bool values when
+	// decoding into map[string]interface{}
+	InferTypes bool
+
+	// List replaces items its key already has, from
+	// earlier parse or definition, instead of adding to them
+	ReplaceLists bool
+
+	// Follow @include directives of file input. Included
+	// files must be within directory of top level file, or
+	// within its file system. Off by default, as input
+	// should not read files it names unless trusted.
+	Includes bool
+
+	// Files ParseAll parses at once, GOMAXPROCS if zero
+	Workers int
+
+	// Resource limits for untrusted input.
+	// Zero means no limit.
+
+	// Maximum length of a single line in bytes
+	MaxLineLength int
+
+	// Maximum total bytes read, includes counted
+	MaxBytes int64
+
+	// Maximum total lines read, includes counted
+	MaxLines int64
+
+	// Maximum items in a single list
+	MaxListLength int64
+
+	// Maximum entries added to a single map,
+	// all of its submaps counted
+	MaxMapSize int64
+
+	// Maximum nesting of @include directives
+	MaxIncludeDepth int64
+
+	// Maximum length of a value expanded by joining
+	// continuation lines or by interpolation
+	MaxExpansion int64
+}
+
 // Parses file into provided template.
-// Template can have only maps string to string.
+// Template is a pointer to struct or to map[string]interface{}.
 func Parse(target interface{}, r io.Reader) (err error) {
-	if elem, err := getElem(target); err == nil {
-		return parseInput(&elem, r)
-	}
-	return
+	return ParseWith(target, r, nil)
 }
 
-// Parses config file with given filename.
-// Returns result as a map of string values.
-func ParseFile(target interface{}, filename string) (err error) {
+// Parses input into provided template using given options.
+func ParseWith(target interface{}, r io.Reader, opts *Options) (err error) {
+	return ParseContext(context.Background(), target, r, opts)
+}
+
+// Parses input into provided template using given options.
+// Parsing stops with context's error once context is done.
+// Violated limits are reported as *LimitError.
+func ParseContext(ctx context.Context, target interface{}, r io.Reader, opts *Options) (err error) {
+	return parseContext(ctx, target, r, nil, "", opts)
+}
+
+// Parses input read from named file, or not a file if name is empty.
+// Includes are read from file system, of OS if nil.
+// Target is either a struct or map[string]interface{}.
+func parseContext(ctx context.Context, target interface{}, r io.Reader, fsys fs.FS, filename string, opts *Options) (err error) {
 	elem, err := getElem(target)
 	if err != nil {
 		return
 	}
 
+	var recv receiver
+	if elem.Type() == schemalessType {
+		recv = newMapReceiver(elem, opts)
+	} else if recv, err = newReflector(elem, opts); err != nil {
+		return
+	}
+	d := newDecoder(ctx, recv, opts)
+	d.fsys = fsys
+	return d.parseInput(r, filename)
+}
+
+// Parses config file with given filename.
+// Returns result as a map of string values.
+func ParseFile(target interface{}, filename string) (err error) {
+	return ParseFileWith(target, filename, nil)
+}
+
+// Parses config file with given filename using given options.
+func ParseFileWith(target interface{}, filename string, opts *Options) (err error) {
+	return ParseFileContext(context.Background(), target, filename, opts)
+}
+
+// Parses config file with given filename using given options
+// and context. Includes, if enabled, are resolved relative
+// to the file.
+func ParseFileContext(ctx context.Context, target interface{}, filename string, opts *Options) (err error) {
+	return parseFile(ctx, target, nil, filename, opts)
+}
+
+// Parses named file of file system, of OS if nil.
+func parseFile(ctx context.Context, target interface{}, fsys fs.FS, filename string, opts *Options) (err error) {
 	// Read config file
-	file, err := os.Open(filename)
+	file, err := openFile(fsys, filename)
 	if err != nil {
 		return fmt.Errorf("error reading file: %s", filename)
 	}
 	defer file.Close()
 
-	return parseInput(&elem, file)
+	return parseContext(ctx, target, file, fsys, filename, opts)
 }
 
 // Read single field specified by key from input file.
 func SeekFile(target interface{}, filename string, key string) (value string, err error) {
-	elem, err := getElem(target)
-	if err != nil {
+	return seekFile(target, nil, filename, key)
+}
+
+// Reads single field of named file of file system, of OS if nil.
+func seekFile(target interface{}, fsys fs.FS, filename string, key string) (value string, err error) {
+	if _, err = getElem(target); err != nil {
 		return
 	}
 
 	// Read config file
-	file, err := os.Open(filename)
+	file, err := openFile(fsys, filename)
 	if err != nil {
 		return "", fmt.Errorf("error reading file: %s", filename)
 	}
 	defer file.Close()
 
-	return seekInput(&elem, file, key)
+	return seekInput(file, key)
 }
 
 // Find first relevant config file in given directory.
-// Pattern is just like in terminal: config_*.ini, *.ini
+// Pattern is matched as by path.Match: config_*.ini, app_?.ini,
+// [ab]*.ini
 // Key is the key inside the file that must be present and matched.
 // Relevance of file is defined by provided function.
 func ParseDir(target interface{}, dir string, pattern string, idkey string, matcher func(string) bool) (err error) {
-	fis, err := ioutil.ReadDir(dir)
+	return ParseDirWith(target, dir, pattern, idkey, matcher, nil)
+}
+
+// Finds first relevant config file in given directory
+// and parses it using given options.
+func ParseDirWith(target interface{}, dir string, pattern string, idkey string, matcher func(string) bool, opts *Options) (err error) {
+	return parseDir(target, nil, dir, pattern, idkey, matcher, opts)
+}
+
+// Finds first relevant config file in directory of file
+// system, of OS if nil.
+func parseDir(target interface{}, fsys fs.FS, dir string, pattern string, idkey string, matcher func(string) bool, opts *Options) (err error) {
+	fis, err := readDir(fsys, dir)
 	if err != nil {
-		return fmt.Errorf("error scanning directory: %s, error = ", dir, err)
+		return fmt.Errorf("error scanning directory: %s, error = %s", dir, err)
 	}
 
-	// Build pattern regex
-	re, err := wildcardRegex(pattern)
-	if err != nil {
+	// Check pattern
+	if err = checkPattern(pattern); err != nil {
 		return err
 	}
 
@@ -80,20 +206,20 @@ func ParseDir(target interface{}, dir string, pattern string, idkey string, matc
 			continue
 		}
 		filename := fi.Name()
-		if !re.MatchString(filename) {
+		if ok, _ := path.Match(pattern, filename); !ok {
 			continue
 		}
 
 		// Seek file for idkey
 		filename = path.Join(dir, filename)
-		idvalue, err := SeekFile(target, filename, idkey)
+		idvalue, err := seekFile(target, fsys, filename, idkey)
 		if err != nil {
 			return fmt.Errorf("Error while seeking file '%s', error: %s", filename, err)
 		}
 
 		// Check if idkey value matches expected
 		if matcher(idvalue) {
-			return ParseFile(target, filename)
+			return parseFile(context.Background(), target, fsys, filename, opts)
 		}
 	}
 	return errors.New("No matching configuration file found")
@@ -2,10 +2,20 @@ package skini
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"unicode/utf16"
     "html/template"
 )
 
@@ -214,26 +224,6 @@ func TestParseReceiver(t *testing.T) {
 	}
 
 	return
-
-	buf = bytes.NewBufferString(inputB)
-	if err := parseReceiver(buf); err != nil {
-		//t.Errorf("Error while parsing: %s", err)
-	}
-
-	buf = bytes.NewBufferString(inputC)
-	if err := parseReceiver(buf); err != nil {
-		//t.Errorf("Error while parsing: %s", err)
-	}
-
-	buf = bytes.NewBufferString(inputD)
-	if err := parseReceiver(buf); err != nil {
-		//t.Errorf("Error while parsing: %s", err)
-	}
-
-	buf = bytes.NewBufferString(inputE)
-	if err := parseReceiver(buf); err != nil {
-		//t.Errorf("Error while parsing: %s", err)
-	}
 }
 
 func parseReceiver(input io.Reader) error {
@@ -285,3 +275,1844 @@ func OFF_TestParseDir(t *testing.T) {
 		t.Errorf("Error while parsing: %s", err)
 	}
 }
+
+// Test pointer, interface and array fields
+//
+type ConfigRefs struct {
+	Id      *string
+	Port    *int
+	Unset   *string
+	Ratio   float64
+	Debug   bool
+	Any     interface{}
+	AnyList interface{}
+	Triple  [3]string
+	Ports   []*int
+
+	ServerHttp *struct {
+		Port *uint16
+	}
+	Absent *struct {
+		Port string
+	}
+
+	Texts interface{}
+	Press interface{}
+}
+
+var inputRefs = `
+id =
+port = 8080
+ratio = 0.5
+debug = true
+any = anything
+anyList =
+    one
+    two
+triple =
+    a
+    b
+    c
+ports =
+    80
+    443
+
+[server.http]
+    port = 9090
+
+[map.texts]
+    hello = Hey there !
+
+[map.press | ABC]
+    logo = smh.png
+`
+
+func TestParsePointersArraysInterfaces(t *testing.T) {
+	cfg := ConfigRefs{}
+	if err := Parse(&cfg, bytes.NewBufferString(inputRefs)); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+
+	if cfg.Id == nil || *cfg.Id != "" {
+		t.Errorf("Expected empty but set Id, got %v", cfg.Id)
+	}
+	if cfg.Port == nil || *cfg.Port != 8080 {
+		t.Errorf("Expected Port 8080, got %v", cfg.Port)
+	}
+	if cfg.Unset != nil {
+		t.Errorf("Expected Unset to stay nil, got %v", *cfg.Unset)
+	}
+	if cfg.Ratio != 0.5 || !cfg.Debug {
+		t.Errorf("Expected Ratio 0.5 and Debug true, got %v, %v", cfg.Ratio, cfg.Debug)
+	}
+	if cfg.Any != "anything" {
+		t.Errorf("Expected Any to hold string, got %#v", cfg.Any)
+	}
+	if list, ok := cfg.AnyList.([]string); !ok || len(list) != 2 {
+		t.Errorf("Expected AnyList to hold []string, got %#v", cfg.AnyList)
+	}
+	if cfg.Triple != [3]string{"a", "b", "c"} {
+		t.Errorf("Unexpected Triple: %v", cfg.Triple)
+	}
+	if len(cfg.Ports) != 2 || *cfg.Ports[1] != 443 {
+		t.Errorf("Unexpected Ports: %v", cfg.Ports)
+	}
+	if cfg.ServerHttp == nil || *cfg.ServerHttp.Port != 9090 {
+		t.Errorf("Expected ServerHttp section allocated, got %v", cfg.ServerHttp)
+	}
+	if cfg.Absent != nil {
+		t.Errorf("Expected Absent section to stay nil")
+	}
+	if m, ok := cfg.Texts.(map[string]string); !ok || m["hello"] != "Hey there !" {
+		t.Errorf("Expected Texts to hold map[string]string, got %#v", cfg.Texts)
+	}
+	if m, ok := cfg.Press.(map[string]map[string]string); !ok || m["ABC"]["logo"] != "smh.png" {
+		t.Errorf("Expected Press to hold map of maps, got %#v", cfg.Press)
+	}
+
+	// Submaps need string keys
+	keyed := struct {
+		Texts map[int]map[string]string
+	}{}
+	err := Parse(&keyed, bytes.NewBufferString("[map.texts | 1]\n    a = b\n"))
+	if err == nil || !strings.Contains(err.Error(), "map key must be string") {
+		t.Errorf("Expected map key error, got %v", err)
+	}
+}
+
+// Test array length checking
+//
+func TestParseArrayLength(t *testing.T) {
+	inputs := []string{
+		"triple =\n    a\n    b\n",
+		"triple =\n    a\n    b\n    c\n    d\n",
+		"triple =\n    a\n    b\nid = x\n",
+	}
+	for _, input := range inputs {
+		cfg := ConfigRefs{}
+		if err := Parse(&cfg, bytes.NewBufferString(input)); err == nil {
+			t.Errorf("Expected array length error for input:\n%s", input)
+		}
+	}
+}
+
+// Test name matching strategies
+//
+type ConfigNames struct {
+	LogDir  string
+	LogFile string `skini:"log_output"`
+	Skipped string `skini:"-"`
+
+	ServerHttp struct {
+		Port string
+	}
+	Press map[string]string
+}
+
+func TestParseNameMatch(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		input string
+	}{
+		{Options{}, "logDir = a\nlog_output = b\n[server.http]\nport = 1\n[map.press]\nx = y\n"},
+		{Options{Names: NamesExact}, "LogDir = a\nlog_output = b\n[ServerHttp]\nPort = 1\n[map.Press]\nx = y\n"},
+		{Options{Names: NamesFold}, "LOGDIR = a\nLOG_OUTPUT = b\n[SERVER.HTTP]\nport = 1\n[map.PRESS]\nx = y\n"},
+		{Options{Names: NamesNormalized}, "log-dir = a\nlog.output = b\n[SERVER.Http]\nPORT = 1\n[map.press]\nx = y\n"},
+		{Options{Names: NamesFunc, NameFunc: func(s string) string {
+			return strings.ToUpper(strings.Replace(s, "/", "", -1))
+		}}, "log/dir = a\nlog_output = b\n[serverhttp]\nport = 1\n[map.press]\nx = y\n"},
+	}
+
+	for i, test := range tests {
+		cfg := ConfigNames{}
+		if err := ParseWith(&cfg, bytes.NewBufferString(test.input), &test.opts); err != nil {
+			t.Errorf("Case %v: error while parsing: %s", i, err)
+			continue
+		}
+		if cfg.LogDir != "a" || cfg.LogFile != "b" || cfg.ServerHttp.Port != "1" || cfg.Press["x"] != "y" {
+			t.Errorf("Case %v: unexpected result: %+v", i, cfg)
+		}
+	}
+
+	// Skipped field is never matched
+	cfg := ConfigNames{}
+	if err := Parse(&cfg, bytes.NewBufferString("skipped = x\n")); err == nil {
+		t.Errorf("Expected error for skipped field")
+	}
+}
+
+// Test detection of fields colliding under name matching
+//
+func TestParseNameCollision(t *testing.T) {
+	cfg := struct {
+		LogDir string
+		Logdir string
+	}{}
+	err := ParseWith(&cfg, bytes.NewBufferString("log_dir = a\n"), &Options{Names: NamesNormalized})
+	if err == nil || !strings.Contains(err.Error(), "both match") {
+		t.Errorf("Expected collision error, got: %v", err)
+	}
+
+	// Camelcase matching tells them apart
+	if err = Parse(&cfg, bytes.NewBufferString("logdir = a\n")); err != nil || cfg.Logdir != "a" {
+		t.Errorf("Expected Logdir set, got: %v, %+v", err, cfg)
+	}
+}
+
+// Test fields promoted from embedded struct pointers
+//
+type ConfigEmbedLog struct {
+	LogDir string
+}
+
+type ConfigEmbedServer struct {
+	Port string
+	*ConfigEmbedServer
+}
+
+type ConfigEmbed struct {
+	*ConfigEmbedLog
+	Server struct {
+		*ConfigEmbedServer
+	}
+}
+
+func TestParseEmbeddedPointer(t *testing.T) {
+	cfg := ConfigEmbed{}
+	if err := Parse(&cfg, bytes.NewBufferString("logDir = a\n")); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if cfg.ConfigEmbedLog == nil || cfg.LogDir != "a" {
+		t.Errorf("Expected embedded pointer allocated and set, got: %+v", cfg)
+	}
+
+	// Not allocated until its field is written
+	if cfg.Server.ConfigEmbedServer != nil {
+		t.Errorf("Expected embedded pointer of untouched section nil")
+	}
+
+	// Embedding pointer to itself is walked once
+	if err := Parse(&cfg, bytes.NewBufferString("[server]\nport = 1\n")); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if cfg.Server.ConfigEmbedServer == nil || cfg.Server.Port != "1" {
+		t.Errorf("Expected section field of embedded pointer set, got: %+v", cfg.Server)
+	}
+}
+
+// Benchmarks
+//
+func makeLargeInput(items int) string {
+	var buf bytes.Buffer
+	buf.WriteString("id = /home\nlogDir = /home/a\nlogFile = my.log\nsupporting =\n")
+	for i := 0; i < items; i++ {
+		fmt.Fprintf(&buf, "    class%v\n", i)
+	}
+	buf.WriteString("[server.http]\n    port = 8080\n    mode = debug\n    colors =\n")
+	for i := 0; i < items; i++ {
+		fmt.Fprintf(&buf, "        color%v\n", i)
+	}
+	buf.WriteString("[map.redirects]\n")
+	for i := 0; i < items; i++ {
+		fmt.Fprintf(&buf, "    ^abc/%v$ = /def/%v\n", i, i)
+	}
+	return buf.String()
+}
+
+// Large single input with long lists and big map
+func BenchmarkParseLarge(b *testing.B) {
+	input := makeLargeInput(10000)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg := Config{}
+		if err := Parse(&cfg, bytes.NewBufferString(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Many small inputs, like tenant configs parsed at startup
+func BenchmarkParseMany(b *testing.B) {
+	input := makeLargeInput(10)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg := Config{}
+		if err := Parse(&cfg, bytes.NewBufferString(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Baselines of field lookup and list growth as done before
+// cached plans: FieldByName per key, slice copied per item.
+var benchKeys = []string{"id", "logDir", "logFile", "supporting", "server.http"}
+
+func BenchmarkFieldLookupBaseline(b *testing.B) {
+	elem := reflect.ValueOf(&Config{}).Elem()
+	for i := 0; i < b.N; i++ {
+		for _, key := range benchKeys {
+			if !elem.FieldByName(toFieldName(key)).IsValid() {
+				b.Fatal(key)
+			}
+		}
+	}
+}
+
+func BenchmarkFieldLookup(b *testing.B) {
+	elem := reflect.ValueOf(&Config{}).Elem()
+	r, err := newReflector(elem, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		for _, key := range benchKeys {
+			if f, _, _ := r.lookup(elem, r.plan, key); !f.IsValid() {
+				b.Fatal(key)
+			}
+		}
+	}
+}
+
+func BenchmarkSliceAddBaseline(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		f := reflect.ValueOf(&[]string{}).Elem()
+		item := reflect.ValueOf("x")
+		for k := 0; k < 10000; k++ {
+			l := f.Len()
+			grown := reflect.MakeSlice(f.Type(), l+1, l+1)
+			reflect.Copy(grown, f)
+			grown.Index(l).Set(item)
+			f.Set(grown)
+		}
+	}
+}
+
+func BenchmarkSliceAdd(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		f := reflect.ValueOf(&[]string{}).Elem()
+		item := reflect.ValueOf("x")
+		for k := 0; k < 10000; k++ {
+			f.Set(reflect.Append(f, item))
+		}
+	}
+}
+
+// Test concurrent parses sharing cached type plans
+//
+func TestParseConcurrent(t *testing.T) {
+	input := makeLargeInput(100)
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			cfg := Config{}
+			err := Parse(&cfg, bytes.NewBufferString(input))
+			if err == nil && (len(cfg.Supporting) != 100 || len(cfg.Redirects) != 100) {
+				err = fmt.Errorf("unexpected result: %v, %v", len(cfg.Supporting), len(cfg.Redirects))
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// Multi-megabyte input
+func BenchmarkParseMegabytes(b *testing.B) {
+	input := makeLargeInput(60000)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg := Config{}
+		if err := Parse(&cfg, bytes.NewBufferString(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Seeking key at the very end of multi-megabyte input
+func BenchmarkSeekMegabytes(b *testing.B) {
+	input := makeLargeInput(60000) + "last = value\n"
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if value, err := seekInput(bytes.NewBufferString(input), "last"); err != nil || value != "value" {
+			b.Fatal(value, err)
+		}
+	}
+}
+
+// Test values parsed from fixture input
+//
+func TestParseFixtureValues(t *testing.T) {
+	cfg := Config{}
+	if err := Parse(&cfg, bytes.NewBufferString(inputA)); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+
+	checks := []struct{ got, expected interface{} }{
+		{cfg.Id, "/home"},
+		{len(cfg.Supporting), 3},
+		{cfg.ServerHttp.Colors[2], "blue"},
+		{cfg.Texts["random"], template.HTML("s=f(x)")},
+		{cfg.Redirects["^bed/bye$"], "lko MUST_APPPEND"},
+		{cfg.Press["ABC"]["blurb"], template.HTML("Hello, this is short SMH blurb Append ABC Second line. Append ABC Third line.")},
+		{cfg.Press["ABC"]["keywords"], template.HTML("")},
+		{cfg.Press["XYZ"]["c"], template.HTML("######")},
+		{cfg.Press["employers-postrole"]["a"], template.HTML("16a")},
+		{len(cfg.Press), 5},
+	}
+	for i, check := range checks {
+		if check.got != check.expected {
+			t.Errorf("Check %v: expected %q, got %q", i, check.expected, check.got)
+		}
+	}
+
+	// Seek reads first key value only
+	for _, f := range []string{"test_config_a.ini", "test_config_b.ini"} {
+		if value, err := SeekFile(&cfg, f, "logFile"); err != nil || value != "my.log" {
+			t.Errorf("Seek in %s: unexpected %q, %v", f, value, err)
+		}
+	}
+}
+
+// Test lines longer than default scanner buffer
+//
+func TestParseLongLines(t *testing.T) {
+	blob := strings.Repeat("QUJD", 100000)
+	input := "id = " + blob + "\r\nsupporting =\n    " + blob + "\n    short\nlogDir = end"
+
+	cfg := Config{}
+	if err := Parse(&cfg, bytes.NewBufferString(input)); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if cfg.Id != blob || len(cfg.Supporting) != 2 || string(cfg.Supporting[0]) != blob || cfg.LogDir != "end" {
+		t.Errorf("Long lines not parsed correctly: %v, %v, %q", len(cfg.Id), len(cfg.Supporting), cfg.LogDir)
+	}
+
+	value, err := seekInput(bytes.NewBufferString(input), "logDir")
+	if err != nil || value != "end" {
+		t.Errorf("Expected to seek past long lines, got %q, %v", value, err)
+	}
+
+	// Limit exceeded is reported with line number
+	cfg = Config{}
+	err = ParseWith(&cfg, bytes.NewBufferString(input), &Options{MaxLineLength: 1000})
+	var perr *ParseError
+	if !errors.As(err, &perr) || !errors.Is(err, ErrLineTooLong) || perr.Line != 1 {
+		t.Errorf("Expected line too long error at line 1, got: %v", err)
+	}
+
+	input = "logDir = /home\nsupporting =\n    " + blob + "\n"
+	err = ParseWith(&cfg, bytes.NewBufferString(input), &Options{MaxLineLength: 1000})
+	if !errors.As(err, &perr) || perr.Line != 3 {
+		t.Errorf("Expected line too long error at line 3, got: %v", err)
+	}
+
+	// Limit itself is fine
+	cfg = Config{}
+	input = "id = " + strings.Repeat("x", 995)
+	if err = ParseWith(&cfg, bytes.NewBufferString(input), &Options{MaxLineLength: 1000}); err != nil {
+		t.Errorf("Expected line at limit to pass, got: %v", err)
+	}
+}
+
+// Test errors report line of input
+//
+func TestParseErrorLine(t *testing.T) {
+	cfg := Config{}
+	err := Parse(&cfg, bytes.NewBufferString("id = a\n\n# comment\nunknown = b\n"))
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 4 || perr.Col != 1 {
+		t.Errorf("Expected error at line 4, got: %v", err)
+	}
+}
+
+// Test byte order marks, line endings and encodings
+//
+func TestParseEncodings(t *testing.T) {
+	text := "id = /home\nsupporting =\n    classA\n    classB\n[server.http]\n    port = 8080\n"
+
+	utf16le := []byte{0xFF, 0xFE}
+	utf16be := []byte{0xFE, 0xFF}
+	for _, r := range text + "    mode = \U0001F600\n" {
+		if r > 0xFFFF {
+			r1, r2 := utf16.EncodeRune(r)
+			utf16le = append(utf16le, byte(r1), byte(r1>>8), byte(r2), byte(r2>>8))
+			utf16be = append(utf16be, byte(r1>>8), byte(r1), byte(r2>>8), byte(r2))
+			continue
+		}
+		utf16le = append(utf16le, byte(r), byte(r>>8))
+		utf16be = append(utf16be, byte(r>>8), byte(r))
+	}
+
+	inputs := map[string][]byte{
+		"plain":    []byte(text),
+		"bom":      append([]byte{0xEF, 0xBB, 0xBF}, text...),
+		"crlf":     []byte(strings.Replace(text, "\n", "\r\n", -1)),
+		"cr":       []byte(strings.Replace(text, "\n", "\r", -1)),
+		"bom crlf": append([]byte{0xEF, 0xBB, 0xBF}, strings.Replace(text, "\n", "\r\n", -1)...),
+		"utf16le":  utf16le,
+		"utf16be":  utf16be,
+	}
+
+	for name, input := range inputs {
+		cfg := Config{}
+		if err := Parse(&cfg, bytes.NewReader(input)); err != nil {
+			t.Errorf("%s: error while parsing: %s", name, err)
+			continue
+		}
+		if cfg.Id != "/home" || len(cfg.Supporting) != 2 || cfg.Supporting[1] != "classB" || cfg.ServerHttp.Port != "8080" {
+			t.Errorf("%s: unexpected result: %q, %q, %q", name, cfg.Id, cfg.Supporting, cfg.ServerHttp.Port)
+		}
+		if strings.HasPrefix(name, "utf16") && cfg.ServerHttp.Mode != "\U0001F600" {
+			t.Errorf("%s: surrogate pair not decoded: %q", name, cfg.ServerHttp.Mode)
+		}
+	}
+
+	// Invalid UTF-8 is reported with position
+	cfg := Config{}
+	err := Parse(&cfg, bytes.NewReader([]byte("id = /home\r\n  logDir = /ho\xffme\r\n")))
+	var perr *ParseError
+	if !errors.Is(err, ErrInvalidUTF8) || !errors.As(err, &perr) || perr.Line != 2 || perr.Col != 15 {
+		t.Errorf("Expected invalid UTF-8 error at line 2, col 15, got: %v", err)
+	}
+}
+
+// Test empty values, empty lists and unset values
+//
+type ConfigEmpty struct {
+	Name    string
+	Title   *string
+	Keys    []string
+	Tags    []string
+	Colors  []string
+	Numbers [0]int
+	Any     interface{}
+	Port    *int
+	Ports   []int
+
+	Texts map[string]string
+}
+
+var inputEmpty = `
+name =
+title =
+keys =
+# no keys yet
+tags =
+numbers =
+any =
+port = @null
+ports = @null
+colors =
+[map.texts]
+    hello = Hey there !
+    bye = See ya :)
+    bye = @null
+    empty =
+`
+
+func TestParseEmptyValues(t *testing.T) {
+	port := 80
+	cfg := ConfigEmpty{Name: "default", Port: &port, Ports: []int{80}}
+	if err := Parse(&cfg, bytes.NewBufferString(inputEmpty)); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+
+	if cfg.Name != "" || cfg.Title == nil || *cfg.Title != "" {
+		t.Errorf("Expected empty strings, got %q, %v", cfg.Name, cfg.Title)
+	}
+	for name, list := range map[string][]string{"keys": cfg.Keys, "tags": cfg.Tags, "colors": cfg.Colors} {
+		if list == nil || len(list) != 0 {
+			t.Errorf("Expected %s to be empty but not nil slice, got %#v", name, list)
+		}
+	}
+	if cfg.Any != "" {
+		t.Errorf("Expected Any to hold empty string, got %#v", cfg.Any)
+	}
+	if cfg.Port != nil || cfg.Ports != nil {
+		t.Errorf("Expected @null to unset Port and Ports, got %v, %v", cfg.Port, cfg.Ports)
+	}
+	if _, ok := cfg.Texts["bye"]; ok || cfg.Texts["hello"] != "Hey there !" {
+		t.Errorf("Expected @null to remove map key, got %v", cfg.Texts)
+	}
+	if value, ok := cfg.Texts["empty"]; !ok || value != "" {
+		t.Errorf("Expected empty map value, got %v", cfg.Texts)
+	}
+
+	// Empty value is not a list for a string at the end of input
+	cfg = ConfigEmpty{}
+	if err := Parse(&cfg, bytes.NewBufferString("keys = \nname = ")); err != nil || cfg.Keys == nil {
+		t.Errorf("Expected empty list and value at end of input, got: %v, %#v", err, cfg)
+	}
+}
+
+// Test resource limits
+//
+func TestParseLimits(t *testing.T) {
+	input := makeLargeInput(100)
+	tests := []struct {
+		opts Options
+		err  error
+	}{
+		{Options{MaxBytes: 1000}, ErrInputTooLarge},
+		{Options{MaxLines: 50}, ErrTooManyLines},
+		{Options{MaxListLength: 99}, ErrListTooLong},
+		{Options{MaxMapSize: 99}, ErrMapTooLarge},
+		{Options{MaxLineLength: 10}, ErrLineTooLong},
+		{Options{MaxBytes: int64(len(input)), MaxLines: 400, MaxListLength: 100, MaxMapSize: 100}, nil},
+	}
+	for i, test := range tests {
+		cfg := Config{}
+		err := ParseWith(&cfg, bytes.NewBufferString(input), &test.opts)
+		var lerr *LimitError
+		if test.err == nil && err != nil || test.err != nil && (!errors.Is(err, test.err) || !errors.As(err, &lerr)) {
+			t.Errorf("Case %v: expected %v, got %v", i, test.err, err)
+		}
+	}
+
+	// Expansion of += text
+	cfg := Config{}
+	input = "logFile += one\n two\n three\n"
+	err := ParseWith(&cfg, bytes.NewBufferString(input), &Options{MaxExpansion: 10})
+	if !errors.Is(err, ErrExpansionTooLarge) {
+		t.Errorf("Expected expansion error, got %v", err)
+	}
+	if err = ParseWith(&cfg, bytes.NewBufferString(input), &Options{MaxExpansion: 20}); err != nil || cfg.LogFile != "one two three" {
+		t.Errorf("Expected expansion within limit, got %v, %q", err, cfg.LogFile)
+	}
+}
+
+// Test cancellation
+//
+func TestParseContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cfg := Config{}
+	err := ParseContext(ctx, &cfg, bytes.NewBufferString(makeLargeInput(1000)), nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+}
+
+// Test includes
+//
+func TestParseIncludes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.ini":        "id = /home\n@include sub/server.ini\nsupporting =\n    classA\n[map.texts]\n    hello = Hi\n@include \"texts.ini\"\n    bye = Bye\n",
+		"sub/server.ini":  "logDir = /var/log\n[server.http]\n    port = 8080\n",
+		"texts.ini":       "[map.texts]\n    random = s=f(x)\n",
+		"cycle.ini":       "id = a\n@include cycle.ini\n",
+		"deep.ini":        "id = a\n@include sub/deeper.ini\n",
+		"sub/deeper.ini":  "@include deepest.ini\n",
+		"sub/deepest.ini": "logDir = b\n",
+	}
+	for name, text := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := &Options{Includes: true}
+	cfg := Config{}
+	if err := ParseFileWith(&cfg, filepath.Join(dir, "main.ini"), opts); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if cfg.Id != "/home" || cfg.LogDir != "/var/log" || cfg.ServerHttp.Port != "8080" || len(cfg.Supporting) != 1 {
+		t.Errorf("Unexpected result: %s", cfg.String())
+	}
+	if cfg.Texts["hello"] != "Hi" || cfg.Texts["random"] != "s=f(x)" || cfg.Texts["bye"] != "Bye" {
+		t.Errorf("Unexpected texts: %v", cfg.Texts)
+	}
+
+	// Cycles and depth
+	err := ParseFileWith(&cfg, filepath.Join(dir, "cycle.ini"), opts)
+	var perr *ParseError
+	if err == nil || !strings.Contains(err.Error(), "include cycle") || !errors.As(err, &perr) || perr.Line != 2 {
+		t.Errorf("Expected include cycle error at line 2, got %v", err)
+	}
+	if err = ParseFileWith(&cfg, filepath.Join(dir, "deep.ini"), opts); err != nil || cfg.LogDir != "b" {
+		t.Errorf("Expected nested includes to work, got %v", err)
+	}
+	err = ParseFileWith(&cfg, filepath.Join(dir, "deep.ini"), &Options{Includes: true, MaxIncludeDepth: 1})
+	if !errors.Is(err, ErrIncludeTooDeep) || !errors.As(err, &perr) || !strings.HasSuffix(perr.File, "deeper.ini") {
+		t.Errorf("Expected include depth error in deeper.ini, got %v", err)
+	}
+
+	// Refused unless enabled, and for input that is not a file
+	cfg = Config{}
+	err = ParseFile(&cfg, filepath.Join(dir, "main.ini"))
+	if err == nil || !strings.Contains(err.Error(), "not enabled") || cfg.LogDir != "" {
+		t.Errorf("Expected includes refused by default, got %v, %+v", err, cfg)
+	}
+	err = ParseWith(&cfg, strings.NewReader("@include "+filepath.Join(dir, "texts.ini")+"\n"), opts)
+	if err == nil || !strings.Contains(err.Error(), "file input") {
+		t.Errorf("Expected include of reader input refused, got %v", err)
+	}
+
+	// Contained within directory of top level file,
+	// where nested include may go up to
+	secret := filepath.Join(dir, "secret.ini")
+	os.WriteFile(secret, []byte("id = secret\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "up.ini"), []byte("@include ../secret.ini\n"), 0644)
+	for _, text := range []string{"@include ../secret.ini\n", "@include " + secret + "\n"} {
+		os.WriteFile(filepath.Join(dir, "sub", "escape.ini"), []byte(text), 0644)
+		err = ParseFileWith(&cfg, filepath.Join(dir, "sub", "escape.ini"), opts)
+		if err == nil || !strings.Contains(err.Error(), "outside") || cfg.Id == "secret" {
+			t.Errorf("Expected include outside of directory refused: %q, got %v", text, err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "up.ini"), []byte("@include sub/up.ini\n"), 0644)
+	if err = ParseFileWith(&cfg, filepath.Join(dir, "up.ini"), opts); err != nil || cfg.Id != "secret" {
+		t.Errorf("Expected include within directory of top level file, got %v", err)
+	}
+}
+
+// Test parsing into map without schema and decoding it later
+//
+func TestParseSchemaless(t *testing.T) {
+	m := map[string]interface{}{}
+	if err := Parse(&m, bytes.NewBufferString(inputA)); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+
+	server, _ := m["server.http"].(map[string]interface{})
+	press, _ := m["Press"].(map[string]interface{})
+	abc, _ := press["ABC"].(map[string]interface{})
+	checks := []struct{ got, expected interface{} }{
+		{m["id"], "/home"},
+		{len(m["supporting"].([]string)), 3},
+		{server["port"], "8080"},
+		{server["colors"].([]string)[2], "blue"},
+		{m["redirects"].(map[string]interface{})["^bed/bye$"], "lko MUST_APPPEND"},
+		{abc["logo"], "smh.png"},
+	}
+	for i, check := range checks {
+		if check.got != check.expected {
+			t.Errorf("Check %v: expected %v, got %v", i, check.expected, check.got)
+		}
+	}
+
+	// Map decodes into struct same as input does
+	cfg, expected := Config{}, Config{}
+	if err := Decode(&cfg, m, nil); err != nil {
+		t.Fatalf("Error while decoding: %s", err)
+	}
+	Parse(&expected, bytes.NewBufferString(inputA))
+	if cfg.String() != expected.String() {
+		t.Errorf("Decoded differs from parsed:\n%s\n%s", cfg.String(), expected.String())
+	}
+
+	// Type inference
+	m = nil
+	input := "a = 8080\nb = true\nc = 1.5\nd = 007\ne = Inf\nf = yes\nl =\n    1\n    two\n"
+	if err := ParseWith(&m, bytes.NewBufferString(input), &Options{InferTypes: true}); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	inferred := map[string]interface{}{"a": 8080, "b": true, "c": 1.5, "d": "007", "e": "Inf", "f": "yes"}
+	for key, value := range inferred {
+		if m[key] != value {
+			t.Errorf("Key %s: expected %#v, got %#v", key, value, m[key])
+		}
+	}
+	if l, ok := m["l"].([]interface{}); !ok || len(l) != 2 || l[0] != 1 || l[1] != "two" {
+		t.Errorf("Unexpected list: %#v", m["l"])
+	}
+
+	// Inferred values decode back into typed fields
+	typed := struct {
+		A int
+		B bool
+		C float64
+		D string
+		E string
+		F string
+		L []string
+	}{}
+	if err := Decode(&typed, m, &Options{Names: NamesFold}); err != nil {
+		t.Fatalf("Error while decoding: %s", err)
+	}
+	if typed.A != 8080 || !typed.B || typed.C != 1.5 || typed.D != "007" || len(typed.L) != 2 {
+		t.Errorf("Unexpected decoded values: %+v", typed)
+	}
+}
+
+//
+// Test inferred values decode back to input unchanged
+//
+func TestParseInferLossless(t *testing.T) {
+	values := []string{"8080", "-12", "1.5", "1.10", "+5", "-0.25", "1e3", "007", "0",
+		"12345678901234567890", "-9223372036854775809", "3.14159265358979323846", "+Inf", "NaN"}
+	for _, value := range values {
+		m := map[string]interface{}{}
+		if err := ParseWith(&m, strings.NewReader("v = "+value+"\n"), &Options{InferTypes: true}); err != nil {
+			t.Fatalf("Error while parsing %s: %s", value, err)
+		}
+		typed := struct{ V string }{}
+		if err := Decode(&typed, m, nil); err != nil {
+			t.Fatalf("Error while decoding %s: %s", value, err)
+		}
+		if typed.V != value {
+			t.Errorf("Expected %s to decode back, got %s from %#v", value, typed.V, m["v"])
+		}
+	}
+}
+
+// Test conversion to other formats and back
+//
+func TestConvert(t *testing.T) {
+	doc, err := ReadDocument(bytes.NewBufferString(inputA))
+	if err != nil {
+		t.Fatalf("Error while reading document: %s", err)
+	}
+	expected := Config{}
+	Parse(&expected, bytes.NewBufferString(inputA))
+
+	formats := []struct {
+		name string
+		to   func(*Document) ([]byte, error)
+		from func([]byte) (*Document, error)
+	}{
+		{"JSON", ToJSON, FromJSON},
+		{"TOML", ToTOML, FromTOML},
+		{"YAML", ToYAML, FromYAML},
+	}
+	for _, f := range formats {
+		data, err := f.to(doc)
+		var cerr *ConvertError
+		if f.name == "JSON" {
+			// Comments are lost
+			if !errors.As(err, &cerr) || cerr.Format != "JSON" || !strings.Contains(cerr.Losses[0], "comment") {
+				t.Errorf("%s: expected comments to be reported, got %v", f.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %s", f.name, err)
+		}
+
+		back, err := f.from(data)
+		if err != nil {
+			t.Fatalf("%s: error converting back: %s\n%s", f.name, err, data)
+		}
+		cfg := Config{}
+		if err = Parse(&cfg, strings.NewReader(back.String())); err != nil {
+			t.Fatalf("%s: error parsing converted: %s\n%s", f.name, err, back)
+		}
+		if cfg.String() != expected.String() {
+			t.Errorf("%s: round trip differs:\n%s\n%s", f.name, cfg.String(), expected.String())
+		}
+	}
+
+	// Includes and nulls
+	doc, _ = ReadDocument(strings.NewReader("id = @null\n@include other.ini\n[map.texts]\n    a = b\n"))
+	_, err = ToTOML(doc)
+	var cerr *ConvertError
+	if !errors.As(err, &cerr) || len(cerr.Losses) != 2 {
+		t.Errorf("Expected null and include to be reported, got %v", err)
+	}
+	if data, err := ToYAML(doc); err == nil || !strings.Contains(string(data), "id: null") {
+		t.Errorf("Expected null to be kept, got %v\n%s", err, data)
+	}
+
+	// Constructs INI cannot represent
+	back, err := FromJSON([]byte(`{"id": 8080, "a": {"b": {"c": "d"}}, "l": [{}], "m": "x\ny", "map.t": {"k": {"x": "1"}}}`))
+	if !errors.As(err, &cerr) || cerr.Format != "INI" || len(cerr.Losses) != 3 {
+		t.Errorf("Expected 3 losses, got %v", err)
+	}
+	if s := back.String(); s != "id = 8080\n\n[a]\n\n[map.t | k]\n    x = 1\n" {
+		t.Errorf("Unexpected document:\n%s", s)
+	}
+
+	// Syntax outside of supported subsets
+	for _, input := range []string{"[[a]]\n", "a = {b = 1}\n", "a = \"\"\"\nb\"\"\"\n"} {
+		if _, err := FromTOML([]byte(input)); err == nil {
+			t.Errorf("Expected TOML error for %q", input)
+		}
+	}
+	for _, input := range []string{"a: &x 1\n", "a: |\n  b\n", "a:\n  - b: c\n"} {
+		if _, err := FromYAML([]byte(input)); err == nil {
+			t.Errorf("Expected YAML error for %q", input)
+		}
+	}
+}
+
+// Test configparser and git config dialects
+//
+func TestParseDialects(t *testing.T) {
+	type Server struct {
+		Host    string
+		Port    int
+		Url     string
+		Debug   bool
+		Motd    string
+		Modules []string
+	}
+	cfg := struct {
+		Name  string
+		Main  Server `skini:"Main Server"`
+		Other Server `skini:"other-server"`
+	}{}
+
+	input := "name=app\n" +
+		"[DEFAULT]\n" +
+		"host: localhost\n" +
+		"debug = true\n" +
+		"[Main Server]\n" +
+		"port=8080\n" +
+		"url = http://%(host)s:%(port)s/100%%\n" +
+		"motd = Hello\n" +
+		"    and welcome\n" +
+		"modules =\n" +
+		"    auth\n" +
+		"    log\n" +
+		"[other-server]\n" +
+		"host = remote\n" +
+		"debug = false\n"
+
+	// Improved INI doesn't understand it
+	if err := Parse(&cfg, strings.NewReader(input)); err == nil {
+		t.Errorf("Expected error parsing configparser input as improved INI")
+	}
+
+	opts := &Options{Dialect: DialectConfigParser}
+	if err := ParseWith(&cfg, strings.NewReader(input), opts); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if cfg.Name != "app" || cfg.Main.Host != "localhost" || !cfg.Main.Debug || cfg.Main.Port != 8080 ||
+		cfg.Main.Url != "http://localhost:8080/100%" || cfg.Main.Motd != "Hello\nand welcome" || len(cfg.Main.Modules) != 2 {
+		t.Errorf("Unexpected main server: %+v", cfg.Main)
+	}
+	if cfg.Other.Host != "remote" || cfg.Other.Debug {
+		t.Errorf("Unexpected other server: %+v", cfg.Other)
+	}
+
+	for _, input := range []string{"[a]\nx = %(missing)s\n", "[a]\nx = 100%\n"} {
+		if err := ParseWith(&cfg, strings.NewReader(input), opts); err == nil {
+			t.Errorf("Expected interpolation error for %q", input)
+		}
+	}
+	err := ParseWith(&cfg, strings.NewReader("[DEFAULT]\na = xxxxxxxx\nb = %(a)s%(a)s%(a)s\n"), &Options{Dialect: DialectConfigParser, MaxExpansion: 16})
+	if !errors.Is(err, ErrExpansionTooLarge) {
+		t.Errorf("Expected expansion limit error, got %v", err)
+	}
+
+	// Git config
+	git := struct {
+		Core struct {
+			Bare     bool
+			Editor   string
+			Excludes string
+		}
+		Remote map[string]map[string]string
+		Branch map[string]map[string]string
+	}{}
+	input = "[core]\n" +
+		"\tbare\n" +
+		"\teditor = \"vim -u \\\"NONE\\\"\" # inline comment\n" +
+		"\texcludes = a \\\n" +
+		"b\n" +
+		"[remote \"origin\"]\n" +
+		"\turl = git@example.com:repo.git\n" +
+		"\tfetch = +refs/heads/*:refs/remotes/origin/*\n" +
+		"[branch.Main]\n" +
+		"\tremote=origin ; comment\n"
+	if err := ParseWith(&git, strings.NewReader(input), &Options{Dialect: DialectGitConfig, Names: NamesFold}); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if !git.Core.Bare || git.Core.Editor != `vim -u "NONE"` || git.Core.Excludes != "a b" {
+		t.Errorf("Unexpected core: %+v", git.Core)
+	}
+	if git.Remote["origin"]["url"] != "git@example.com:repo.git" || git.Remote["origin"]["fetch"] != "+refs/heads/*:refs/remotes/origin/*" {
+		t.Errorf("Unexpected remote: %v", git.Remote)
+	}
+	if git.Branch["main"]["remote"] != "origin" {
+		t.Errorf("Unexpected branch: %v", git.Branch)
+	}
+
+	err = ParseWith(&git, strings.NewReader("[core]\n\tbare = \"yes\n"), &Options{Dialect: DialectGitConfig})
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 2 || !strings.Contains(err.Error(), "unterminated") {
+		t.Errorf("Expected unterminated quote error at line 2, got %v", err)
+	}
+}
+
+// Test origins of values recorded in meta
+//
+func TestParseMeta(t *testing.T) {
+	meta := &Meta{}
+	cfg := Config{}
+	input := "id = /home\nid = /root\nunknown = x\nsupporting =\n    a\n    b\n[server.http]\n    port = 8080\n[map.press | ABC]\n    logo = a.png\n"
+
+	// Unknown keys fail unless ignored
+	if err := ParseWith(&cfg, strings.NewReader(input), &Options{Meta: meta}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected unknown key error, got %v", err)
+	}
+
+	meta = &Meta{}
+	if err := ParseWith(&cfg, strings.NewReader(input), &Options{Meta: meta, IgnoreUnknown: true}); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	checks := []struct {
+		path string
+		line int
+		raw  string
+	}{
+		{"id", 2, "id = /root"},
+		{"supporting", 4, "supporting ="},
+		{"server.http.port", 8, "port = 8080"},
+		{"map.press.ABC.logo", 10, "logo = a.png"},
+	}
+	for _, check := range checks {
+		o := meta.Lookup(check.path)
+		if o == nil || o.Line != check.line || o.Raw != check.raw || o.Source != SourceFile {
+			t.Errorf("Path %s: unexpected origin %+v", check.path, o)
+		}
+	}
+	if len(meta.Unused) != 2 || meta.Unused[0].Raw != "id = /home" || meta.Unused[1].Path != "unknown" {
+		t.Errorf("Unexpected unused: %s", meta)
+	}
+
+	// Defaults and files
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "app.ini")
+	os.WriteFile(filename, []byte("[DEFAULT]\nhost = localhost\nspare = 1\n[main]\nport = 80\n"), 0644)
+	app := struct {
+		Main struct {
+			Host string
+			Port int
+		}
+	}{}
+	meta = &Meta{}
+	if err := ParseFileWith(&app, filename, &Options{Dialect: DialectConfigParser, Names: NamesFold, Meta: meta}); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if o := meta.Lookup("main.host"); o == nil || o.String() != filename+":2 (default)" {
+		t.Errorf("Unexpected origin of default: %v", o)
+	}
+	if o := meta.Lookup("main.port"); o == nil || o.String() != filename+":5" {
+		t.Errorf("Unexpected origin: %v", o)
+	}
+	if len(meta.Unused) != 1 || meta.Unused[0].Path != "DEFAULT.spare" {
+		t.Errorf("Unexpected unused: %s", meta)
+	}
+}
+
+//
+// Test secret fields and value references
+//
+func TestSecrets(t *testing.T) {
+	type Secrets struct {
+		User     string
+		Password string `skini:"password,secret"`
+		Note     string
+		Db       struct {
+			Port  int
+			Token int `skini:",secret"`
+		}
+		Keys map[string]string `skini:",secret"`
+	}
+
+	// Secret values stay out of errors and meta
+	meta := &Meta{}
+	cfg := Secrets{}
+	err := ParseWith(&cfg, strings.NewReader("[db]\n    token = hunter2\n"), &Options{Meta: meta})
+	if err == nil || strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), Redacted) {
+		t.Errorf("Expected redacted error, got %v", err)
+	}
+	if err = ParseWith(&cfg, strings.NewReader("password = hunter2\n"), &Options{Meta: meta}); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if o := meta.Lookup("password"); o == nil || o.Raw != "password = "+Redacted {
+		t.Errorf("Expected redacted origin, got %+v", o)
+	}
+
+	// References
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "db")
+	os.WriteFile(filename, []byte("s3cret\n"), 0600)
+	os.Setenv("SKINI_TEST_USER", "admin")
+	defer os.Unsetenv("SKINI_TEST_USER")
+
+	input := "user = @env:SKINI_TEST_USER\npassword = @file:" + filename + "\nnote = @@env:HOME\n[map.keys]\n    a = @vault:a\n"
+	opts := &Options{Secrets: DefaultSecrets}
+	cfg = Secrets{}
+	if err = ParseWith(&cfg, strings.NewReader(input), opts); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if cfg.User != "admin" || cfg.Password != "s3cret" || cfg.Note != "@env:HOME" || cfg.Keys["a"] != "@vault:a" {
+		t.Errorf("Unexpected result: %+v", cfg)
+	}
+	if err = ParseWith(&cfg, strings.NewReader("user = @env:SKINI_TEST_UNSET\n"), opts); err == nil {
+		t.Errorf("Expected error for unset variable")
+	}
+
+	// Kept as written unless resolver is set, @@ included
+	cfg = Secrets{}
+	if err = Parse(&cfg, strings.NewReader(input)); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if cfg.User != "@env:SKINI_TEST_USER" || cfg.Password != "@file:"+filename || cfg.Note != "@@env:HOME" {
+		t.Errorf("Expected references kept, got: %+v", cfg)
+	}
+	if err = Parse(&cfg, strings.NewReader("password = @file:x\n")); err != nil || cfg.Password != "@file:x" {
+		t.Errorf("Expected @file:x kept, got: %v, %+v", err, cfg)
+	}
+
+	// Custom resolver, references kept by NoSecrets
+	vault := SecretResolverFunc(func(ctx context.Context, scheme, ref string) (string, error) {
+		if scheme != "vault" {
+			return "", ErrNotReference
+		}
+		return "v-" + ref, nil
+	})
+	cfg = Secrets{}
+	if err = ParseWith(&cfg, strings.NewReader(input), &Options{Secrets: vault}); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if cfg.Keys["a"] != "v-a" || cfg.User != "@env:SKINI_TEST_USER" {
+		t.Errorf("Unexpected result: %+v", cfg)
+	}
+	cfg = Secrets{}
+	if err = ParseWith(&cfg, strings.NewReader(input), &Options{Secrets: NoSecrets}); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if cfg.Password != "@file:"+filename || cfg.Note != "@env:HOME" {
+		t.Errorf("Unexpected result: %+v", cfg)
+	}
+
+	// Marshal redacts and escapes
+	cfg.Password, cfg.Note, cfg.Db.Port, cfg.Db.Token = "s3cret", "@env:HOME", 5432, 42
+	expected := "user = @@env:SKINI_TEST_USER\npassword = ********\nnote = @@env:HOME\n\n[db]\n    port = 5432\n    token = ********\n\n[map.keys]\n    a = ********\n"
+	if s := String(&cfg); s != expected {
+		t.Errorf("Unexpected marshal:\n%s", s)
+	}
+	escaped := Secrets{}
+	if err = ParseWith(&escaped, strings.NewReader("user = @@env:SKINI_TEST_USER\nnote = @@env:HOME\n"), &Options{Secrets: NoSecrets}); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if escaped.User != cfg.User || escaped.Note != "@env:HOME" {
+		t.Errorf("Expected escaped references read back, got: %+v", escaped)
+	}
+
+	// Marshal output parses back
+	data, err := Marshal(&Config{})
+	if err != nil {
+		t.Fatalf("Error while marshaling: %s", err)
+	}
+	expectedCfg := Config{}
+	if err = Parse(&expectedCfg, strings.NewReader(inputA)); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if data, err = Marshal(&expectedCfg); err != nil {
+		t.Fatalf("Error while marshaling: %s", err)
+	}
+	back := Config{}
+	if err = ParseWith(&back, bytes.NewReader(data), &Options{Secrets: NoSecrets}); err != nil {
+		t.Fatalf("Error while parsing marshaled: %s\n%s", err, data)
+	}
+	if back.String() != expectedCfg.String() {
+		t.Errorf("Round trip differs:\n%s\n%s", back.String(), expectedCfg.String())
+	}
+}
+
+//
+// Test flags and environment over parsed input
+//
+func TestOverlay(t *testing.T) {
+	cfg := Config{LogDir: "/var/log"}
+	cfg.ServerHttp.Port = "80"
+	meta := &Meta{}
+	opts := &Options{Meta: meta}
+
+	o, err := NewOverlay(&cfg, "APP", opts)
+	if err != nil {
+		t.Fatalf("Error creating overlay: %s", err)
+	}
+	keys := strings.Join(o.Keys(), " ")
+	if keys != "id log.dir log.file supporting server.http.port server.http.mode server.http.keys server.http.colors" {
+		t.Errorf("Unexpected keys: %s", keys)
+	}
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	o.RegisterFlags(fs)
+	if err = fs.Parse([]string{"--server.http.port=9090", "--supporting", "x", "--supporting", "y"}); err != nil {
+		t.Fatalf("Error parsing flags: %s", err)
+	}
+	if err = ParseWith(&cfg, strings.NewReader(inputA), opts); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+
+	os.Setenv("APP_SERVER_HTTP_PORT", "8888")
+	os.Setenv("APP_SERVER_HTTP_MODE", "release")
+	os.Setenv("APP_SERVER_HTTP_COLORS", "cyan, magenta")
+	defer func() {
+		for _, name := range []string{"APP_SERVER_HTTP_PORT", "APP_SERVER_HTTP_MODE", "APP_SERVER_HTTP_COLORS"} {
+			os.Unsetenv(name)
+		}
+	}()
+	if err = o.Apply(); err != nil {
+		t.Fatalf("Error applying overlay: %s", err)
+	}
+
+	if cfg.ServerHttp.Port != "9090" || cfg.ServerHttp.Mode != "release" || cfg.LogDir != "/home/a" ||
+		fmt.Sprint(cfg.ServerHttp.Colors) != "[cyan magenta]" || fmt.Sprint(cfg.Supporting) != "[x y]" {
+		t.Errorf("Unexpected result: %s", cfg.String())
+	}
+	if o := meta.Lookup("server.http.port"); o == nil || o.Source != SourceOverride {
+		t.Errorf("Unexpected origin: %+v", o)
+	}
+	if o := meta.Lookup("server.http.mode"); o == nil || o.Source != SourceEnv || o.Raw != "APP_SERVER_HTTP_MODE=release" {
+		t.Errorf("Unexpected origin: %+v", o)
+	}
+
+	var help bytes.Buffer
+	o.PrintDefaults(&help)
+	if !regexp.MustCompile(`--server.http.port +APP_SERVER_HTTP_PORT +80 +override`).MatchString(help.String()) ||
+		!regexp.MustCompile(`--log.dir +APP_LOG_DIR +/var/log +line 4`).MatchString(help.String()) {
+		t.Errorf("Unexpected help:\n%s", help.String())
+	}
+}
+
+//
+// Test template generated from struct
+//
+func TestGenerateTemplate(t *testing.T) {
+	type App struct {
+		Name  string `desc:"Name of application" skini:",required"`
+		Debug bool
+		Hosts []string `desc:"Hosts to serve"`
+		Db    struct {
+			Port     int    `desc:"Database port"`
+			Password string `skini:",secret"`
+		} `desc:"Database connection"`
+		Texts map[string]string
+		Press map[string]map[string]string `desc:"Press releases"`
+	}
+	app := App{Name: "demo"}
+	app.Db.Port = 5432
+
+	data, err := GenerateTemplate(&app)
+	if err != nil {
+		t.Fatalf("Error generating template: %s", err)
+	}
+	expected := `# Name of application
+# default: demo
+# required
+name = demo
+# default: false
+debug = false
+# Hosts to serve
+hosts =
+    # item
+
+# Database connection
+[db]
+    # Database port
+    # default: 5432
+    port = 5432
+    # password = @env:NAME
+
+[map.texts]
+    # key = value
+
+# Press releases
+# [map.press | name]
+#     key = value
+`
+	if string(data) != expected {
+		t.Errorf("Unexpected template:\n%s", data)
+	}
+
+	back := App{}
+	if err = Parse(&back, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Template doesn't parse back: %s", err)
+	}
+	if back.Name != "demo" || back.Db.Port != 5432 || len(back.Hosts) != 0 {
+		t.Errorf("Unexpected result: %+v", back)
+	}
+
+	// Lists of empty items are written with item placeholders
+	lists := struct {
+		Tags  [2]string
+		Names []string
+		Ids   []string
+	}{Ids: []string{"a", ""}}
+	if data, err = GenerateTemplate(&lists); err != nil {
+		t.Fatalf("Error generating template: %s", err)
+	}
+	expected = "tags =\n    string\n    string\nnames =\n    # item\n# default: a, \"\"\nids =\n    a\n    string\n"
+	if string(data) != expected {
+		t.Errorf("Unexpected template:\n%s", data)
+	}
+	if err = Parse(&lists, bytes.NewReader(data)); err != nil || lists.Tags[1] != "string" || len(lists.Names) != 0 {
+		t.Errorf("Template doesn't parse back: %v, %+v\n%s", err, lists, data)
+	}
+
+	// Type of Config
+	if data, err = GenerateTemplate(reflect.TypeOf(Config{})); err != nil {
+		t.Fatalf("Error generating template: %s", err)
+	}
+	if err = Parse(&Config{}, bytes.NewReader(data)); err != nil {
+		t.Errorf("Template doesn't parse back: %s\n%s", err, data)
+	}
+}
+
+//
+// Test JSON Schema export and validation
+//
+func TestSchema(t *testing.T) {
+	type Server struct {
+		Port  int    `desc:"Listen port" min:"1" max:"65535"`
+		Mode  string `enum:"debug,release" skini:",required"`
+		Hosts [2]string
+	}
+	type App struct {
+		Id         string `pattern:"^[a-z]+$" skini:",required"`
+		Debug      bool
+		ServerHttp Server
+		Texts      map[string]string
+		Press      map[string]map[string]string
+	}
+
+	data, err := JSONSchema(&App{Debug: true})
+	if err != nil {
+		t.Fatalf("Error generating schema: %s", err)
+	}
+	schema, err := ReadSchema(data)
+	if err != nil {
+		t.Fatalf("Error reading schema: %s", err)
+	}
+	port := schema.Properties["serverHttp"].Properties["port"]
+	if !schema.Closed || port.Type != "integer" || *port.Maximum != 65535 || port.Description != "Listen port" ||
+		schema.Properties["debug"].Default != true || schema.Properties["map.press"].AdditionalProperties.Type != "object" {
+		t.Errorf("Unexpected schema:\n%s", data)
+	}
+
+	valid := "id = abc\n[server.http]\n    port = 80\n    mode = debug\n    hosts =\n        a\n        b\n[map.texts]\n    x = y\n[map.press | ABC]\n    logo = a.png\n"
+	doc, _ := ReadDocument(strings.NewReader(valid))
+	if err = schema.Validate(doc, "app.ini"); err != nil {
+		t.Errorf("Unexpected validation error: %s", err)
+	}
+
+	invalid := "id = ABC\nextra = 1\n[server.http]\n    port = 0\n    hosts =\n        a\n[map.texts | sub]\n    x = y\n"
+	doc, _ = ReadDocument(strings.NewReader(invalid))
+	err = schema.Validate(doc, "app.ini")
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected validation error, got %v", err)
+	}
+	expected := []string{
+		"app.ini:1: id: must match ^[a-z]+$: ABC",
+		"app.ini:2: unknown key: extra",
+		"app.ini:4: port: out of range: 0",
+		"app.ini:5: hosts has 1 items, expected 2",
+		"app.ini:7: map texts has no submaps",
+		"app.ini:3: missing required key: mode",
+	}
+	if got := strings.Join(strings.Split(err.Error(), "\n"), "|"); got != strings.Join(expected, "|") {
+		t.Errorf("Unexpected errors:\n%s", err)
+	}
+	for i, col := range []int{1, 1, 5, 5, 1, 1} {
+		if i < len(verr.Errors) && verr.Errors[i].Col != col {
+			t.Errorf("Error %v: expected column %v, got %v", i, col, verr.Errors[i].Col)
+		}
+	}
+
+	// Spellings matching same input name resolve the same way every time
+	spelled := &Schema{Properties: map[string]*Schema{"logDir": {Type: "string"}, "LogDir": {Type: "integer"}}}
+	for i := 0; i < 20; i++ {
+		if prop := spelled.Property("log.dir"); prop == nil || prop.Type != "integer" {
+			t.Fatalf("Expected first spelling in sorted order, got %+v", prop)
+		}
+	}
+}
+
+//
+// Test canonical formatting
+//
+func TestFormat(t *testing.T) {
+	input := "# Top\nid =   x\nid   = /home\nsupporting =\n  a\n\n\n      b\n[server.http]\n  port = 8080\n        # Mode\n        mode = debug\n[map.texts]\n  long += one two three four\n  five six seven = eight\n  b = 2\n  @include other.ini\n  a = 1\n# End\n"
+
+	out, err := Format([]byte(input), nil)
+	if err != nil {
+		t.Fatalf("Error formatting: %s", err)
+	}
+	expected := "# Top\nid = x\nid = /home\nsupporting =\n    a\n    b\n\n[server.http]\n    port = 8080\n    # Mode\n    mode = debug\n\n[map.texts]\n    long += one two three four\n    five six seven = eight\n    b = 2\n    @include other.ini\n    a = 1\n# End\n"
+	if string(out) != expected {
+		t.Errorf("Unexpected format:\n%s", out)
+	}
+
+	style := &FormatStyle{Indent: 2, AlignEquals: true, SortKeys: true, Width: 24}
+	input = "[map.texts]\n  long += one two three four five six [seven] eight nine = ten\n  b = 2\n  a = 1\n"
+	if out, err = Format([]byte(input), style); err != nil {
+		t.Fatalf("Error formatting: %s", err)
+	}
+	expected = "[map.texts]\n  a     = 1\n  b     = 2\n  long += one two three\n  four five six [seven] eight nine = ten\n"
+	if string(out) != expected {
+		t.Errorf("Unexpected format:\n%s", out)
+	}
+
+	// Idempotent and same meaning
+	for _, s := range []*FormatStyle{nil, style} {
+		first, _ := Format([]byte(inputA), s)
+		second, _ := Format(first, s)
+		if !bytes.Equal(first, second) {
+			t.Errorf("Format not idempotent:\n%s\n%s", first, second)
+		}
+		cfg, expected := Config{}, Config{}
+		Parse(&expected, strings.NewReader(inputA))
+		if err = Parse(&cfg, bytes.NewReader(first)); err != nil || cfg.String() != expected.String() {
+			t.Errorf("Formatted input reads differently: %v\n%s", err, first)
+		}
+	}
+}
+
+//
+// Test keys padded to align = read as keys without padding
+//
+func TestParsePaddedKeys(t *testing.T) {
+	input := "id     = /home\nlogDir \t= /var/log\nsupporting   =\n    classA\n[server.http]\n    port   = 8080\n    mode  += debug\n        mode\n[map.texts]\n    hello    = Hi\n"
+
+	cfg := Config{}
+	if err := Parse(&cfg, strings.NewReader(input)); err != nil {
+		t.Fatalf("Error while parsing: %s", err)
+	}
+	if cfg.Id != "/home" || cfg.LogDir != "/var/log" || len(cfg.Supporting) != 1 || cfg.ServerHttp.Port != "8080" ||
+		cfg.ServerHttp.Mode != "debug mode" || cfg.Texts["hello"] != "Hi" {
+		t.Errorf("Unexpected result: %s", cfg.String())
+	}
+
+	doc, err := ReadDocument(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Error reading document: %s", err)
+	}
+	var keys []string
+	for _, b := range doc.Blocks {
+		for _, e := range b.Entries {
+			keys = append(keys, e.Key)
+		}
+	}
+	if got := strings.Join(keys, ","); got != "id,logDir,supporting,port,mode,hello" {
+		t.Errorf("Unexpected document keys: %s", got)
+	}
+}
+
+//
+// Test semantic diff of documents and structs
+//
+func TestDiff(t *testing.T) {
+	a := "id = x\nold = 1\nhosts =\n    a\n    b\n    c\n[server]\n    port = 80\n[map.texts | en]\n    hi = Hello\n"
+	b := "# Reordered\nhosts =\n    a\n    x\n    c\n    d\nid = x\n[map.texts | en]\n    hi = Hi\n[server]\n    mode = debug\n    port = 80\n"
+	docA, _ := ReadDocument(strings.NewReader(a))
+	docB, _ := ReadDocument(strings.NewReader(b))
+
+	changes, err := Diff(docA, docB)
+	if err != nil {
+		t.Fatalf("Error diffing: %s", err)
+	}
+	expected := "~ hosts[1]: b -> x\n+ hosts[3] = d\n~ map.texts|en.hi: Hello -> Hi\n- old = 1\n+ server.mode = debug\n"
+	if changes.String() != expected {
+		t.Errorf("Unexpected changes:\n%s", changes)
+	}
+	data, _ := json.Marshal(changes[:2])
+	if string(data) != `[{"kind":"changed","new":"x","old":"b","path":"hosts[1]"},{"kind":"added","new":"d","path":"hosts[3]"}]` {
+		t.Errorf("Unexpected JSON: %s", data)
+	}
+	if changes, _ = Diff(docA, docA); len(changes) != 0 {
+		t.Errorf("Expected no changes, got:\n%s", changes)
+	}
+
+	// Structs, secrets compared but not shown
+	type app struct {
+		Id       string
+		Password string `skini:",secret"`
+		Limits   map[string]int
+	}
+	x := &app{Id: "a", Password: "one", Limits: map[string]int{"cpu": 2}}
+	y := &app{Id: "a", Password: "two", Limits: map[string]int{"cpu": 2, "mem": 512}}
+	if changes, err = Diff(x, y); err != nil {
+		t.Fatalf("Error diffing: %s", err)
+	}
+	expected = "+ map.limits.mem = 512\n~ password: ******** -> ********\n"
+	if changes.String() != expected {
+		t.Errorf("Unexpected changes:\n%s", changes)
+	}
+
+	// Names match as parser matches them
+	type server struct {
+		ServerHttp struct {
+			Port int
+		}
+	}
+	s := &server{}
+	s.ServerHttp.Port = 80
+	docS, _ := ReadDocument(strings.NewReader("[server.http]\n    port = 81\n"))
+	if changes, err = Diff(docS, s); err != nil || changes.String() != "~ server.http.port: 81 -> 80\n" {
+		t.Errorf("Unexpected changes: %v\n%s", err, changes)
+	}
+
+	// Long lists: common ends are skipped, long rest is
+	// compared by position
+	hosts := func(n int, prefix string) []string {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = fmt.Sprintf("%s%v", prefix, i)
+		}
+		return items
+	}
+	long := hosts(5000, "h")
+	edited := append(append(append([]string{}, long[:2500]...), "new"), long[2500:]...)
+	changes = diffItems("hosts", long, edited, false)
+	if changes.String() != "+ hosts[2500] = new\n" {
+		t.Errorf("Unexpected changes:\n%s", changes)
+	}
+	changes = diffItems("hosts", long, hosts(5000, "x"), false)
+	if len(changes) != 5000 || changes[4999].String() != "~ hosts[4999]: h4999 -> x4999" {
+		t.Errorf("Unexpected positional changes: %v", len(changes))
+	}
+}
+
+//
+// Test three-way merge of documents
+//
+func TestMerge3(t *testing.T) {
+	base := "id = app\nmode = debug\nhosts =\n    a\n    b\n[server]\n    port = 80\n    host = localhost\n[old]\n    x = 1\n"
+	ours := "# Edited\nid = app\nmode = release\nhosts =\n    a\n    b\n    c\n[server]\n    # Ours\n    port = 9090\n    host = localhost\n[old]\n    x = 1\n"
+	theirs := "id = app\nmode = debug\nlevel = info\nhosts =\n    a\n    d\n[server]\n    port = 8080\n    host = 0.0.0.0\n    timeout = 5\n[new]\n    y = 2\n"
+	docs := make([]*Document, 3)
+	for i, s := range []string{base, ours, theirs} {
+		docs[i], _ = ReadDocument(strings.NewReader(s))
+	}
+
+	before := docs[1].String()
+	merged, conflicts := Merge3(docs[0], docs[1], docs[2])
+	expected := "# Edited\nid = app\nmode = release\nlevel = info\nhosts =\n    a\n    d\n    c\n\n[server]\n    # Ours\n    # <<<<<<< theirs\n    # port = 8080\n    # >>>>>>> ours\n    port = 9090\n    host = 0.0.0.0\n    timeout = 5\n\n[new]\n    y = 2\n"
+	if merged.String() != expected {
+		t.Errorf("Unexpected merge:\n%s", merged)
+	}
+	if len(conflicts) != 1 || conflicts[0].String() != "conflict at server.port: ours port = 9090, theirs port = 8080" {
+		t.Errorf("Unexpected conflicts: %v", conflicts)
+	}
+	if docs[1].String() != before {
+		t.Errorf("Ours changed by merge:\n%s", docs[1])
+	}
+
+	// Merged document still parses
+	if _, err := ReadDocument(strings.NewReader(merged.String())); err != nil {
+		t.Errorf("Error reading merged: %s", err)
+	}
+
+	// Key removed from every repeated section, conflicting
+	// text kept apart from markers
+	base = "[server]\n    port = 80\n[other]\n    motd = hi\n[server]\n    port = 80\n"
+	ours = "[server]\n    port = 80\n[other]\n    motd += ours\n[server]\n    port = 80\n"
+	theirs = "[other]\n    motd += theirs\n"
+	for i, s := range []string{base, ours, theirs} {
+		docs[i], _ = ReadDocument(strings.NewReader(s))
+	}
+	merged, conflicts = Merge3(docs[0], docs[1], docs[2])
+	expected = "[other]\n    # <<<<<<< theirs\n    # motd = theirs\n    # >>>>>>> ours\n    motd += ours\n"
+	if merged.String() != expected || len(conflicts) != 1 {
+		t.Errorf("Unexpected merge of repeated sections:\n%s", merged)
+	}
+	read, err := ReadDocument(strings.NewReader(merged.String()))
+	if err != nil || len(read.Blocks) != 2 || len(read.Blocks[1].Entries[0].Comments) != 3 {
+		t.Errorf("Conflict comments read back differently: %v\n%s", err, merged)
+	}
+
+	// Text written with += before conflict keeps its value
+	base = "blurb += hello world\nport = 80\n"
+	ours = "blurb += hello world\nport = 9090\n"
+	theirs = "blurb += hello world\nport = 8080\n"
+	for i, s := range []string{base, ours, theirs} {
+		docs[i], _ = ReadDocument(strings.NewReader(s))
+	}
+	merged, _ = Merge3(docs[0], docs[1], docs[2])
+	m := map[string]interface{}{}
+	if err = Parse(&m, strings.NewReader(merged.String())); err != nil || m["blurb"] != "hello world" || m["port"] != "9090" {
+		t.Errorf("Merged text read back differently: %v, %v\n%s", err, m, merged)
+	}
+
+	// And before conflict of key ours removed, trailing its section
+	base = "[a]\n    t += x y\n    k = 1\n[b]\n    z = 1\n"
+	ours = "[a]\n    t += x y\n[b]\n    z = 1\n"
+	theirs = "[a]\n    t += x y\n    k = 2\n[b]\n    z = 1\n"
+	for i, s := range []string{base, ours, theirs} {
+		docs[i], _ = ReadDocument(strings.NewReader(s))
+	}
+	merged, _ = Merge3(docs[0], docs[1], docs[2])
+	if read, err = ReadDocument(strings.NewReader(merged.String())); err != nil || read.Blocks[1].Entries[0].Value != "x y" {
+		t.Errorf("Merged text read back differently: %v\n%s", err, merged)
+	}
+}
+
+//
+// Test building documents
+//
+func TestBuilder(t *testing.T) {
+	doc := NewDocument()
+	doc.Root().Comment("Application").Set("id", "/home").Set("empty", "")
+	doc.Section("server.http").HeaderComment("Server").List("colors", "red", "green").Text("motd", "Welcome,\n  stranger")
+	doc.Map("Press", "ABC").Text("blurb", "Long text = fine").Comment("Follows text").Set("x", "1")
+	doc.Map("texts", "").Include("other file.ini").Set("id", "/srv")
+	if err := doc.Err(); err != nil {
+		t.Fatalf("Error building: %s", err)
+	}
+
+	expected := "# Application\nid = /home\nempty =\n\n# Server\n[server.http]\n    colors =\n        red\n        green\n    motd += Welcome, stranger\n\n[map.Press | ABC]\n    blurb = Long text = fine\n    # Follows text\n    x = 1\n\n[map.texts]\n    @include \"other file.ini\"\n    id = /srv\n"
+	if doc.String() != expected {
+		t.Errorf("Unexpected document:\n%s", doc)
+	}
+
+	// Reads back into same structure
+	read, err := ReadDocument(strings.NewReader(doc.String()))
+	if err != nil {
+		t.Fatalf("Error reading built document: %s", err)
+	}
+	for i, b := range read.Blocks {
+		built := doc.Blocks[i]
+		if b.Header() != built.Header() || !reflect.DeepEqual(b.Comments, built.Comments) || len(b.Entries) != len(built.Entries) {
+			t.Fatalf("Block %s read back differently", built.Header())
+		}
+		for k, e := range b.Entries {
+			e.Line, e.Col = 0, 0
+			if !reflect.DeepEqual(e, built.Entries[k]) {
+				t.Errorf("Entry read back differently: %#v", e)
+			}
+		}
+	}
+
+	// Invalid input stops building
+	doc = NewDocument()
+	doc.Root().Set("a", "1").List("b", "ok", "k = v").Set("c", "3")
+	if err = doc.Err(); err == nil || err.Error() != `error, invalid list item: "k = v"` {
+		t.Errorf("Expected invalid list item, got %v", err)
+	}
+	if len(doc.Blocks[0].Entries) != 1 {
+		t.Errorf("Expected building to stop, got:\n%s", doc)
+	}
+	for _, err := range []error{
+		NewDocument().Section("a b").doc.Err(),
+		NewDocument().Root().Set("# x", "1").doc.Err(),
+		NewDocument().Root().Set("x", "a\nb").doc.Err(),
+	} {
+		if err == nil {
+			t.Errorf("Expected error")
+		}
+	}
+}
+
+//
+// Test walking input as events
+//
+func TestWalk(t *testing.T) {
+	input := "id = x\n# Hosts\nhosts =\n    a\n    # Within list\n    b\n[server.http]\n    blurb += Long\n        text\n    port = 80\n[map.texts | en]\n    @include other.ini\n    hi = Hello\n"
+	var events []string
+	err := Walk(strings.NewReader(input), func(ev *Event) error {
+		s := fmt.Sprintf("%v:%v %s|%s|%s", ev.Line, ev.Kind, ev.Name, ev.Key, ev.Value)
+		if ev.Append {
+			s += "+"
+		}
+		events = append(events, s)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error walking: %s", err)
+	}
+	expected := []string{
+		"1:2 id||x", "3:3 hosts||", "4:4 hosts||a", "6:4 hosts||b",
+		"7:0 server.http||", "8:2 blurb||Long+", "9:5 blurb||text", "10:2 port||80",
+		"11:1 texts|en|", "12:6 ||other.ini", "13:2 hi||Hello",
+	}
+	if strings.Join(events, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("Unexpected events:\n%s", strings.Join(events, "\n"))
+	}
+
+	// Handler stops early
+	count := 0
+	err = Walk(strings.NewReader(input), func(ev *Event) error {
+		if count++; ev.Kind == EventSection {
+			return StopWalk
+		}
+		return nil
+	})
+	if err != nil || count != 5 {
+		t.Errorf("Expected stop at section, got %v after %v events", err, count)
+	}
+
+	// Handler errors are positioned
+	failed := errors.New("failed")
+	err = Walk(strings.NewReader(input), func(ev *Event) error {
+		if ev.Name == "port" {
+			return failed
+		}
+		return nil
+	})
+	if !errors.Is(err, failed) || err.Error() != "line 10: failed" {
+		t.Errorf("Expected positioned handler error, got %v", err)
+	}
+
+	// Syntax and limits as in Parse
+	if err = Walk(strings.NewReader("a = 1\n    b\n"), func(*Event) error { return nil }); err == nil {
+		t.Errorf("Expected value without key error")
+	}
+	err = WalkContext(context.Background(), strings.NewReader(input), func(*Event) error { return nil }, &Options{MaxListLength: 1})
+	var lerr *LimitError
+	if !errors.As(err, &lerr) {
+		t.Errorf("Expected limit error, got %v", err)
+	}
+}
+
+//
+// Test parsing many files at once
+//
+func TestParseAll(t *testing.T) {
+	type tenant struct {
+		Id   string
+		Plan string
+	}
+	dir := t.TempDir()
+	for i := 0; i < 40; i++ {
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("tenant_%02d.ini", i)), []byte(fmt.Sprintf("id = t%v\nplan = basic\n", i)), 0644)
+	}
+	os.WriteFile(filepath.Join(dir, "tenant_bad.ini"), []byte("id = x\nunknown = 1\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "other.txt"), []byte("id = y\n"), 0644)
+
+	results, err := ParseAll(dir, "tenant_*.ini", func() interface{} { return &tenant{} }, &Options{Workers: 4, Meta: &Meta{}})
+	if err != nil {
+		t.Fatalf("Error parsing: %s", err)
+	}
+	if len(results) != 41 {
+		t.Fatalf("Expected 41 results, got %v", len(results))
+	}
+	for i := 0; i < 40; i++ {
+		res := results[filepath.Join(dir, fmt.Sprintf("tenant_%02d.ini", i))]
+		if res == nil || res.Err != nil || res.Target.(*tenant).Id != fmt.Sprintf("t%v", i) {
+			t.Fatalf("Unexpected result of file %v: %+v", i, res)
+		}
+		if origin := res.Meta.Lookup("id"); origin == nil || origin.Line != 1 {
+			t.Errorf("Expected own meta of file %v, got %+v", i, origin)
+		}
+	}
+	if res := results[filepath.Join(dir, "tenant_bad.ini")]; res == nil || res.Err == nil {
+		t.Errorf("Expected error of bad file")
+	}
+
+	// Cancelled context fails files
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, _ = ParseAllContext(ctx, dir, "*.ini", func() interface{} { return &tenant{} }, nil)
+	for file, res := range results {
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("Expected cancelled %s, got %v", file, res.Err)
+		}
+	}
+}
+
+//
+// Test parsing files of io/fs file systems
+//
+func TestFS(t *testing.T) {
+	type app struct {
+		Id     string
+		Port   int
+		Common string
+	}
+	fsys := fstest.MapFS{
+		"conf/app_a.ini":    {Data: []byte("id = a\n@include inc/port.ini\n")},
+		"conf/app_b.ini":    {Data: []byte("id = b\n@include /shared/common.ini\nport = 2\n")},
+		"conf/app_ab.ini":   {Data: []byte("id = ab\n")},
+		"conf/inc/port.ini": {Data: []byte("port = 1\n")},
+		"shared/common.ini": {Data: []byte("common = yes\n")},
+	}
+
+	opts := &Options{Includes: true}
+	cfg := &app{}
+	if err := ParseFileFSWith(cfg, fsys, "conf/app_a.ini", opts); err != nil || cfg.Port != 1 {
+		t.Errorf("Error parsing with relative include: %v, %+v", err, cfg)
+	}
+	if id, err := SeekFileFS(cfg, fsys, "conf/app_b.ini", "id"); err != nil || id != "b" {
+		t.Errorf("Error seeking: %v, %s", err, id)
+	}
+
+	// Single character and class patterns
+	cfg = &app{}
+	err := ParseDirFSWith(cfg, fsys, "conf", "app_[b-z].ini", "id", func(id string) bool { return true }, opts)
+	if err != nil || cfg.Id != "b" || cfg.Common != "yes" || cfg.Port != 2 {
+		t.Errorf("Error parsing dir: %v, %+v", err, cfg)
+	}
+	cfg = &app{}
+	err = ParseDirFS(cfg, fsys, "conf", "app_??.ini", "id", func(id string) bool { return true })
+	if err != nil || cfg.Id != "ab" {
+		t.Errorf("Error parsing dir: %v, %+v", err, cfg)
+	}
+	if err = ParseDirFS(cfg, fsys, "conf", "app_[.ini", "id", nil); err == nil {
+		t.Errorf("Expected invalid pattern error")
+	}
+
+	// Includes stay within file system
+	fsys["conf/escape.ini"] = &fstest.MapFile{Data: []byte("@include ../../outside.ini\n")}
+	err = ParseFileFSWith(&app{}, fsys, "conf/escape.ini", opts)
+	if err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("Expected include outside of file system to fail")
+	}
+}
+
+//
+// Test embedded defaults overlaid by files on disk
+//
+func TestDefaults(t *testing.T) {
+	type app struct {
+		Id     string
+		Hosts  []string
+		Server struct {
+			Port int
+			Mode string
+		}
+		Limits map[string]string
+	}
+	defaults := &Defaults{
+		FS: fstest.MapFS{
+			"defaults.ini": {Data: []byte("id = app\nhosts =\n    a\n    b\n[server]\n    port = 80\n    mode = debug\n[map.limits]\n    cpu = 1\n")},
+		},
+		Name: "defaults.ini",
+	}
+
+	dir := t.TempDir()
+	local := filepath.Join(dir, "app.ini")
+	os.WriteFile(local, []byte("hosts =\n    c\n[server]\n    port = 8080\n"), 0644)
+	dropIns := filepath.Join(dir, "app.d")
+	os.Mkdir(dropIns, 0755)
+	os.WriteFile(filepath.Join(dropIns, "20-mode.ini"), []byte("[server]\n    mode = release\n"), 0644)
+	os.WriteFile(filepath.Join(dropIns, "10-limits.ini"), []byte("[map.limits]\n    mem = 2\n[server]\n    mode = test\n"), 0644)
+	os.WriteFile(filepath.Join(dropIns, "readme.txt"), []byte("not config"), 0644)
+
+	cfg := &app{}
+	overridden, err := defaults.Parse(cfg, nil, local, dropIns, filepath.Join(dir, "missing.ini"))
+	if err != nil {
+		t.Fatalf("Error parsing: %s", err)
+	}
+	if cfg.Id != "app" || strings.Join(cfg.Hosts, ",") != "c" || cfg.Server.Port != 8080 || cfg.Server.Mode != "release" || len(cfg.Limits) != 2 {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	var got []string
+	for _, o := range overridden {
+		got = append(got, fmt.Sprintf("%s %s:%v", o.Path, filepath.Base(o.File), o.Line))
+	}
+	if strings.Join(got, ", ") != "hosts app.ini:1, server.mode 20-mode.ini:2, server.port app.ini:4" {
+		t.Errorf("Unexpected overridden keys: %v", got)
+	}
+
+	var out bytes.Buffer
+	if _, err = defaults.WriteTo(&out); err != nil || !strings.HasPrefix(out.String(), "id = app\n") {
+		t.Errorf("Unexpected dump: %v\n%s", err, &out)
+	}
+}
@@ -0,0 +1,236 @@
+package skini
+
+/*
+Template -- annotated example input generated from struct.
+Field tags document keys:
+  Port int `desc:"Port to listen on" skini:"port,required"`
+becomes
+  # Port to listen on
+  # default: 0
+  # required
+  port = 0
+
+Lists and maps without values get commented placeholders,
+secret fields are commented out.
+*/
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Placeholder of list item
+const templateItem = "# item"
+
+// Writes example input of struct, walking it as the parser
+// would. Target is struct, pointer to struct or its
+// reflect.Type. Current values of fields are defaults.
+// Output parses back into the struct.
+func GenerateTemplate(target interface{}) ([]byte, error) {
+	var elem reflect.Value
+	if typ, ok := target.(reflect.Type); ok {
+		elem = reflect.New(typ).Elem()
+	} else {
+		elem = reflect.ValueOf(target)
+	}
+	for elem.Kind() == reflect.Ptr {
+		if elem.IsNil() {
+			elem = reflect.New(elem.Type().Elem())
+		}
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("error, can only generate template of struct, got: %v", elem.Kind())
+	}
+
+	g := &generator{}
+	if err := g.fields(&g.root, elem, "", false); err != nil {
+		return nil, err
+	}
+
+	out := g.root.String()
+	for _, b := range g.blocks {
+		if out != "" {
+			out += "\n"
+		}
+		out += b.String()
+	}
+	return []byte(out), nil
+}
+
+//------------------------------------------------------------
+// Generator
+//------------------------------------------------------------
+
+// Generator collects root keys and blocks following them
+type generator struct {
+	root   strings.Builder
+	blocks []*strings.Builder
+}
+
+// Starts block with header and its comments.
+func (g *generator) block(sf reflect.StructField, header string, commented bool) *strings.Builder {
+	b := &strings.Builder{}
+	writeFieldComments(b, "", sf, "")
+	if commented {
+		b.WriteString("# ")
+	}
+	b.WriteString(header + "\n")
+	g.blocks = append(g.blocks, b)
+	return b
+}
+
+// Writes fields of root or section struct.
+func (g *generator) fields(b *strings.Builder, elem reflect.Value, indent string, secret bool) (err error) {
+	typ := elem.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		field := elem.Field(i)
+
+		if sf.Anonymous && sf.Tag.Get("skini") == "" {
+			if field.Kind() == reflect.Ptr {
+				field = templateElem(field)
+			}
+			if field.Kind() == reflect.Struct {
+				if err = g.fields(b, field, indent, secret); err != nil {
+					return
+				}
+			}
+			continue
+		}
+
+		name := fieldInputName(sf)
+		if name == "" {
+			continue
+		}
+		if name == sf.Name {
+			name = lowerFirst(name)
+		}
+		if !isValidKey(name) {
+			return fmt.Errorf("error, invalid key: %s", name)
+		}
+		if err = g.field(b, sf, name, field, indent, secret || hasTagOption(sf, "secret")); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Writes single field.
+func (g *generator) field(b *strings.Builder, sf reflect.StructField, name string, field reflect.Value, indent string, secret bool) error {
+	f := templateElem(field)
+
+	switch f.Kind() {
+
+	case reflect.Struct:
+		if indent != "" {
+			return fmt.Errorf("error, sections cannot nest: %s", name)
+		}
+		section := g.block(sf, "["+name+"]", false)
+		return g.fields(section, f, "    ", secret)
+
+	case reflect.Map:
+		if indent != "" {
+			return fmt.Errorf("error, maps must be at root: %s", name)
+		}
+		if f.Type().Elem().Kind() == reflect.Map {
+			// Empty submap block would add a key
+			b := g.block(sf, "[map."+name+" | name]", true)
+			b.WriteString("#     key = value\n")
+			return nil
+		}
+		b := g.block(sf, "[map."+name+"]", false)
+		b.WriteString("    # key = value\n")
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		// Empty items, as of zero arrays, are written as
+		// name of their type to keep list length
+		var items, defs []string
+		def := false
+		for i := 0; i < f.Len(); i++ {
+			item, err := formatField(f.Index(i), name, false)
+			if err != nil {
+				return err
+			}
+			if item == "" {
+				defs = append(defs, `""`)
+				item = f.Type().Elem().String()
+			} else {
+				defs = append(defs, item)
+				def = true
+			}
+			if !isValidItem(item) {
+				return fmt.Errorf("error, list item cannot be written: %s = %s", name, item)
+			}
+			items = append(items, item)
+		}
+		if !def {
+			defs = nil
+		}
+
+		if secret {
+			writeFieldComments(b, indent, sf, "")
+			b.WriteString(indent + "# " + name + " =\n")
+			return nil
+		}
+		writeFieldComments(b, indent, sf, strings.Join(defs, ", "))
+		b.WriteString(indent + name + " =\n")
+		if len(items) == 0 {
+			items = []string{templateItem}
+		}
+		for _, item := range items {
+			b.WriteString(indent + "    " + item + "\n")
+		}
+		return nil
+	}
+
+	value, err := formatField(f, name, false)
+	if err != nil {
+		return err
+	}
+	if !isValidValue(value) {
+		return fmt.Errorf("error, value cannot be written: %s = %s", name, value)
+	}
+
+	// Secrets are never written, not even defaults
+	if secret {
+		writeFieldComments(b, indent, sf, "")
+		b.WriteString(indent + "# " + name + " = @env:NAME\n")
+		return nil
+	}
+	writeFieldComments(b, indent, sf, value)
+	b.WriteString(indent + name + " = " + value + "\n")
+	return nil
+}
+
+// Writes description, default and required mark of field.
+// Default is empty for secrets.
+func writeFieldComments(b *strings.Builder, indent string, sf reflect.StructField, def string) {
+	if desc := sf.Tag.Get("desc"); desc != "" {
+		for _, line := range strings.Split(desc, "\n") {
+			b.WriteString(strings.TrimRight(indent+"# "+line, " ") + "\n")
+		}
+	}
+	if def != "" {
+		b.WriteString(indent + "# default: " + def + "\n")
+	}
+	if hasTagOption(sf, "required") {
+		b.WriteString(indent + "# required\n")
+	}
+}
+
+// Follows pointers, using zero values in place of nil.
+func templateElem(field reflect.Value) reflect.Value {
+	for field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return reflect.New(field.Type().Elem()).Elem()
+		}
+		field = field.Elem()
+	}
+	return field
+}
@@ -0,0 +1,429 @@
+package skini
+
+/*
+TOML -- converts documents to and from a subset of TOML:
+tables, dotted and quoted keys, strings, arrays of scalars,
+and numbers, booleans and dates read as text.
+Multi-line strings, inline tables and arrays of tables
+are not supported. TOML has no null.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------
+// Export
+//------------------------------------------------------------
+
+// Converts document into TOML. Returns *ConvertError
+// along with output if anything could not be represented.
+func ToTOML(doc *Document) ([]byte, error) {
+	ls := &losses{format: "TOML"}
+	t := docToTree(doc, formatCaps{name: "TOML", comments: true}, ls)
+
+	var buf bytes.Buffer
+	writeTOMLTable(&buf, t, nil)
+	writeTOMLComments(&buf, t.trailing)
+	return buf.Bytes(), ls.err()
+}
+
+// Writes keys of table followed by its subtables.
+func writeTOMLTable(buf *bytes.Buffer, t *tree, path []string) {
+	for _, key := range t.keys {
+		v := t.values[key]
+		if _, ok := v.(*tree); ok {
+			continue
+		}
+		writeTOMLComments(buf, t.comments[key])
+		buf.WriteString(tomlKey(key) + " = ")
+		if list, ok := v.([]string); ok {
+			writeJSON(buf, list, "")
+		} else {
+			buf.WriteString(jsonQuote(v.(string)))
+		}
+		buf.WriteByte('\n')
+	}
+
+	for _, key := range t.keys {
+		sub, ok := t.values[key].(*tree)
+		if !ok {
+			continue
+		}
+		subpath := append(append([]string{}, path...), tomlKey(key))
+
+		// Header is implied by subtables unless table has own keys
+		if len(t.comments[key]) > 0 || hasTOMLKeys(sub) || len(sub.keys) == 0 {
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			writeTOMLComments(buf, t.comments[key])
+			buf.WriteString("[" + strings.Join(subpath, ".") + "]\n")
+		}
+		writeTOMLTable(buf, sub, subpath)
+	}
+}
+
+// Has table keys other than subtables ?
+func hasTOMLKeys(t *tree) bool {
+	for _, v := range t.values {
+		if _, ok := v.(*tree); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes comments, ';' comments become '#' ones.
+func writeTOMLComments(buf *bytes.Buffer, comments []string) {
+	for _, c := range comments {
+		buf.WriteString("#" + c[1:] + "\n")
+	}
+}
+
+// Quotes key unless it is a bare key.
+func tomlKey(key string) string {
+	for i := 0; i < len(key); i++ {
+		if !isTOMLBare(key[i]) {
+			return jsonQuote(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+func isTOMLBare(c byte) bool {
+	return isAlnum(c) || c == '_' || c == '-'
+}
+
+//------------------------------------------------------------
+// Import
+//------------------------------------------------------------
+
+// Converts TOML into document. Returns *ConvertError
+// along with document if anything could not be represented.
+func FromTOML(data []byte) (doc *Document, err error) {
+	if !utf8.Valid(data) {
+		return nil, ErrInvalidUTF8
+	}
+	p := &tomlParser{s: string(data), line: 1, root: newTree()}
+	if err = p.parse(); err != nil {
+		return nil, err
+	}
+
+	ls := &losses{format: "INI"}
+	return treeToDoc(p.root, ls), ls.err()
+}
+
+// TOML subset parser
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+
+	root *tree
+
+	// Table being filled
+	table *tree
+
+	// Comments waiting for next key
+	comments []string
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return &ParseError{Line: p.line, Err: fmt.Errorf(format, args...)}
+}
+
+func (p *tomlParser) parse() (err error) {
+	p.table = p.root
+	for {
+		p.skipBlank(true)
+		if p.pos == len(p.s) {
+			break
+		}
+
+		if p.s[p.pos] == '[' {
+			err = p.parseHeader()
+		} else {
+			err = p.parseKeyValue()
+		}
+		if err != nil {
+			return
+		}
+
+		// Rest of the line can only be comment
+		p.skipBlank(false)
+		if p.pos < len(p.s) && p.s[p.pos] != '\n' {
+			return p.errorf("error, unexpected text: %s", p.rest())
+		}
+	}
+	p.root.trailing = p.comments
+	return
+}
+
+// Skips whitespace and comments. Newlines too if asked.
+func (p *tomlParser) skipBlank(newlines bool) {
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			if !newlines {
+				return
+			}
+			p.pos++
+			p.line++
+		case '#':
+			end := strings.IndexByte(p.s[p.pos:], '\n')
+			if end < 0 {
+				end = len(p.s) - p.pos
+			}
+			p.comments = append(p.comments, strings.TrimRight(p.s[p.pos:p.pos+end], " \t\r"))
+			p.pos += end
+		default:
+			return
+		}
+	}
+}
+
+// Rest of current line
+func (p *tomlParser) rest() string {
+	s := p.s[p.pos:]
+	if end := strings.IndexByte(s, '\n'); end >= 0 {
+		s = s[:end]
+	}
+	return s
+}
+
+// Parses [table] header, table is created on the way.
+func (p *tomlParser) parseHeader() (err error) {
+	if strings.HasPrefix(p.s[p.pos:], "[[") {
+		return p.errorf("error, arrays of tables are not supported: %s", p.rest())
+	}
+	p.pos++
+	path, err := p.parseKey()
+	if err != nil {
+		return
+	}
+	if p.pos == len(p.s) || p.s[p.pos] != ']' {
+		return p.errorf("error, expected ]: %s", p.rest())
+	}
+	p.pos++
+
+	t := p.root
+	for i, key := range path {
+		if i == len(path)-1 {
+			t.comments[key] = append(t.comments[key], p.comments...)
+			p.comments = nil
+		}
+		if t = t.subtree(key, p.line); t == nil {
+			return p.errorf("error, key is not a table: %s", key)
+		}
+	}
+	p.table = t
+	return
+}
+
+// Parses key = value within current table.
+func (p *tomlParser) parseKeyValue() (err error) {
+	path, err := p.parseKey()
+	if err != nil {
+		return
+	}
+	if p.pos == len(p.s) || p.s[p.pos] != '=' {
+		return p.errorf("error, expected =: %s", p.rest())
+	}
+	p.pos++
+	p.skipBlank(false)
+
+	// Dotted keys go into subtables
+	t := p.table
+	for _, key := range path[:len(path)-1] {
+		if t = t.subtree(key, p.line); t == nil {
+			return p.errorf("error, key is not a table: %s", key)
+		}
+	}
+	key, line := path[len(path)-1], p.line
+	if _, ok := t.values[key]; ok {
+		return p.errorf("error, duplicate key: %s", key)
+	}
+	t.comments[key] = p.comments
+	p.comments = nil
+
+	v, err := p.parseValue()
+	if err == nil {
+		t.set(key, v, line)
+	}
+	return
+}
+
+// Parses dotted key: bare, "basic" or 'literal' parts.
+func (p *tomlParser) parseKey() (path []string, err error) {
+	for {
+		p.skipBlank(false)
+		var key string
+		switch {
+		case p.pos == len(p.s):
+			return nil, p.errorf("error, expected key")
+		case p.s[p.pos] == '"' || p.s[p.pos] == '\'':
+			if key, err = p.parseString(); err != nil {
+				return
+			}
+		default:
+			start := p.pos
+			for p.pos < len(p.s) && isTOMLBare(p.s[p.pos]) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("error, expected key: %s", p.rest())
+			}
+			key = p.s[start:p.pos]
+		}
+		path = append(path, key)
+
+		p.skipBlank(false)
+		if p.pos == len(p.s) || p.s[p.pos] != '.' {
+			return
+		}
+		p.pos++
+	}
+}
+
+// Parses string, array or any other scalar read as text.
+func (p *tomlParser) parseValue() (v interface{}, err error) {
+	if p.pos == len(p.s) {
+		return nil, p.errorf("error, expected value")
+	}
+
+	switch c := p.s[p.pos]; c {
+	case '"', '\'':
+		return p.parseString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return nil, p.errorf("error, inline tables are not supported: %s", p.rest())
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n,]#", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	// Date and time can be separated by a space
+	if p.pos+1 < len(p.s) && p.s[p.pos] == ' ' && p.pos-start == 10 && p.s[start+4] == '-' && '0' <= p.s[p.pos+1] && p.s[p.pos+1] <= '9' {
+		p.pos++
+		for p.pos < len(p.s) && strings.IndexByte(" \t\r\n,]#", p.s[p.pos]) < 0 {
+			p.pos++
+		}
+	}
+	if start == p.pos {
+		return nil, p.errorf("error, expected value: %s", p.rest())
+	}
+	return p.s[start:p.pos], nil
+}
+
+// Arrays of scalars convert into lists. Arrays holding
+// anything else are read but cannot be converted.
+func (p *tomlParser) parseArray() (v interface{}, err error) {
+	p.pos++
+	items := []string{}
+	var what lost
+	for {
+		p.skipBlank(true)
+		if p.pos == len(p.s) {
+			return nil, p.errorf("error, expected ]")
+		}
+		if p.s[p.pos] == ']' {
+			p.pos++
+			break
+		}
+
+		item, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := item.(string); ok {
+			items = append(items, s)
+		} else {
+			what = "array of arrays"
+		}
+
+		p.skipBlank(true)
+		if p.pos < len(p.s) && p.s[p.pos] == ',' {
+			p.pos++
+		} else if p.pos < len(p.s) && p.s[p.pos] != ']' {
+			return nil, p.errorf("error, expected , or ]: %s", p.rest())
+		}
+	}
+	// Comments within array stay with next key
+	if what != "" {
+		return what, nil
+	}
+	return items, nil
+}
+
+// Parses "basic" or 'literal' single line string.
+func (p *tomlParser) parseString() (s string, err error) {
+	quote := p.s[p.pos]
+	if strings.HasPrefix(p.s[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", p.errorf("error, multi-line strings are not supported: %s", p.rest())
+	}
+	p.pos++
+
+	var sb strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return sb.String(), nil
+		case c == '\n':
+			return "", p.errorf("error, unterminated string")
+		case c == '\\' && quote == '"':
+			if err = p.parseEscape(&sb); err != nil {
+				return
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", p.errorf("error, unterminated string")
+}
+
+// Parses escape sequence following backslash.
+func (p *tomlParser) parseEscape(sb *strings.Builder) error {
+	if p.pos == len(p.s) {
+		return p.errorf("error, unterminated string")
+	}
+	c := p.s[p.pos]
+	p.pos++
+
+	if i := strings.IndexByte(`btnfr"\`, c); i >= 0 {
+		sb.WriteByte("\b\t\n\f\r\"\\"[i])
+		return nil
+	}
+
+	size := 4
+	switch c {
+	case 'U':
+		size = 8
+	case 'u':
+	default:
+		return p.errorf("error, invalid escape: \\%c", c)
+	}
+	if p.pos+size > len(p.s) {
+		return p.errorf("error, invalid escape: \\%c", c)
+	}
+	r, err := strconv.ParseUint(p.s[p.pos:p.pos+size], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(r)) {
+		return p.errorf("error, invalid escape: \\%c%s", c, p.s[p.pos:p.pos+size])
+	}
+	p.pos += size
+	sb.WriteRune(rune(r))
+	return nil
+}
@@ -6,7 +6,7 @@ Util -- helper functions.
 
 import (
 	"fmt"
-	"regexp"
+	"path"
     "unicode"
 )
 
@@ -42,27 +42,12 @@ func toFieldName(s string) string {
     return string(out)
 }
 
-// Converts wildcard based filename pattern into regex
-func wildcardRegex(pattern string) (re *regexp.Regexp, err error) {
-	runes := []rune(pattern)
-	expr := []rune{}
-	expr = append(expr, []rune("^")...)
-	for i := 0; i < len(runes); i++ {
-		switch runes[i] {
-		case rune('.'):
-			expr = append(expr, []rune(`\.`)...)
-		case rune('*'):
-			expr = append(expr, []rune(`.*`)...)
-		default:
-			expr = append(expr, runes[i])
-		}
-	}
-	expr = append(expr, []rune("$")...)
-
-	re, err = regexp.Compile(string(expr))
-	if err != nil {
-		return re, fmt.Errorf("error compiling regex for pattern: %s", pattern)
-	}
-	return
+// Checks filename pattern, like config_*.ini, has
+// syntax of path.Match.
+func checkPattern(pattern string) error {
+    if _, err := path.Match(pattern, ""); err != nil {
+        return fmt.Errorf("error, invalid pattern: %s", pattern)
+    }
+    return nil
 }
 
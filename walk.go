@@ -0,0 +1,194 @@
+package skini
+
+/*
+Walk -- streams input as events without building
+anything in memory. Input is read by the same lexer
+as Parse reads it, line by line:
+
+  [server]            EventSection     Name: server
+  [map.texts | en]    EventMap         Name: texts, Key: en
+  port = 80           EventKeyValue    Name: port, Value: 80
+  hosts =             EventListStart   Name: hosts
+      a               EventListItem    Name: hosts, Value: a
+  blurb += Long       EventKeyValue    Name: blurb, Value: Long, Append
+      text            EventTextAppend  Name: blurb, Value: text
+  @include x.ini      EventInclude     Value: x.ini
+
+Comments are skipped, includes are not followed.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Event kinds
+type EventKind int
+
+const (
+	// [section]
+	EventSection EventKind = iota
+
+	// [map.name] or [map.name | key]
+	EventMap
+
+	// key = value, key += value
+	EventKeyValue
+
+	// key = followed by list items
+	EventListStart
+
+	// Item of list
+	EventListItem
+
+	// Line continuing key += value. Value of key is its
+	// lines joined with single space.
+	EventTextAppend
+
+	// @include path
+	EventInclude
+)
+
+// Event of walk. Walk reuses single event, copy it to keep it.
+type Event struct {
+	Kind EventKind
+
+	// Line of input, from 1
+	Line int
+
+	// Section or map name, key of value, list or text
+	Name string
+
+	// Submap key
+	Key string
+
+	// Value, list item, text line or include path
+	Value string
+
+	// Key is written with +=
+	Append bool
+}
+
+// Handles event. Returning StopWalk ends walk without error,
+// any other error ends walk with it, as *ParseError at line
+// of event.
+type EventHandler func(ev *Event) error
+
+// Stops walk when returned by handler
+var StopWalk = errors.New("stop walk")
+
+// Walks input calling handler for every event.
+func Walk(r io.Reader, handler EventHandler) error {
+	return WalkContext(context.Background(), r, handler, nil)
+}
+
+// Walks input within context and limits of options.
+// Input is in improved syntax whatever options' dialect.
+func WalkContext(ctx context.Context, r io.Reader, handler EventHandler, opts *Options) error {
+	b := newBudget(ctx, opts)
+	b.opts.Dialect = DialectImproved
+	w := &walker{lex: newLexer(r, b), budget: b, handler: handler}
+
+	err := w.walk()
+	if errors.Is(err, StopWalk) {
+		return nil
+	}
+	return err
+}
+
+// Walker of input
+type walker struct {
+	lex     *lexer
+	budget  *budget
+	handler EventHandler
+	ev      Event
+
+	// List being walked and its length
+	list  string
+	items int
+}
+
+// Walks all lines, or until handler fails.
+func (w *walker) walk() (err error) {
+	for {
+		tok, err := w.lex.next()
+		if err != nil {
+			return errorAt("", tok, err)
+		}
+		if tok.typ == tokEOF {
+			return nil
+		}
+		if tok.typ == tokComment {
+			continue
+		}
+		if err = w.line(tok); err != nil {
+			return errorAt("", tok, err)
+		}
+	}
+}
+
+// Emits events of line.
+func (w *walker) line(tok *token) (err error) {
+	if tok.typ == tokValue {
+		if w.list == "" {
+			return fmt.Errorf("error, value without key: %s", tok.raw)
+		}
+		if err = w.budget.listItem(w.items); err != nil {
+			return
+		}
+		w.items++
+		return w.emit(Event{Kind: EventListItem, Line: tok.line, Name: w.list, Value: tok.value})
+	}
+	w.list = ""
+
+	switch tok.typ {
+	case tokSection:
+		return w.emit(Event{Kind: EventSection, Line: tok.line, Name: tok.name})
+
+	case tokMap:
+		return w.emit(Event{Kind: EventMap, Line: tok.line, Name: tok.name, Key: tok.value})
+
+	case tokInclude:
+		return w.emit(Event{Kind: EventInclude, Line: tok.line, Value: tok.value})
+
+	case tokKeyValue:
+		if tok.plus {
+			return w.text(tok)
+		}
+		next, err := w.lex.peek()
+		if err != nil {
+			return err
+		}
+		if tok.value == "" && isValue(next) {
+			w.list, w.items = tok.name, 0
+			return w.emit(Event{Kind: EventListStart, Line: tok.line, Name: tok.name})
+		}
+		return w.emit(Event{Kind: EventKeyValue, Line: tok.line, Name: tok.name, Value: tok.value})
+	}
+	return
+}
+
+// Emits key += value and lines that continue it.
+func (w *walker) text(tok *token) (err error) {
+	if err = w.emit(Event{Kind: EventKeyValue, Line: tok.line, Name: tok.name, Value: tok.value, Append: true}); err != nil {
+		return
+	}
+	for {
+		next, err := continuation(w.lex)
+		if next == nil || err != nil {
+			return err
+		}
+		if err = w.emit(Event{Kind: EventTextAppend, Line: next.line, Name: tok.name, Value: next.raw}); err != nil {
+			return err
+		}
+		w.lex.skip()
+	}
+}
+
+// Hands event to handler.
+func (w *walker) emit(ev Event) error {
+	w.ev = ev
+	return w.handler(&w.ev)
+}
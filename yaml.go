@@ -0,0 +1,401 @@
+package skini
+
+/*
+YAML -- converts documents to and from a subset of YAML:
+block mappings, block sequences of scalars, flow sequences
+of scalars, plain and quoted scalars, null and comments.
+Anchors, tags, block scalars, flow mappings and multiple
+documents are not supported. Scalars are read as text.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//------------------------------------------------------------
+// Export
+//------------------------------------------------------------
+
+// Converts document into YAML. Returns *ConvertError
+// along with output if anything could not be represented.
+func ToYAML(doc *Document) ([]byte, error) {
+	ls := &losses{format: "YAML"}
+	t := docToTree(doc, formatCaps{name: "YAML", nulls: true, comments: true}, ls)
+
+	var buf bytes.Buffer
+	writeYAML(&buf, t, "")
+	writeYAMLComments(&buf, "", t.trailing)
+	return buf.Bytes(), ls.err()
+}
+
+// Writes mapping indented by two spaces per level.
+func writeYAML(buf *bytes.Buffer, t *tree, indent string) {
+	for _, key := range t.keys {
+		writeYAMLComments(buf, indent, t.comments[key])
+		buf.WriteString(indent + yamlScalar(key) + ":")
+
+		switch v := t.values[key].(type) {
+		case nil:
+			buf.WriteString(" null\n")
+		case string:
+			buf.WriteString(" " + yamlScalar(v) + "\n")
+		case []string:
+			if len(v) == 0 {
+				buf.WriteString(" []\n")
+				break
+			}
+			buf.WriteByte('\n')
+			for _, item := range v {
+				buf.WriteString(indent + "  - " + yamlScalar(item) + "\n")
+			}
+		case *tree:
+			if len(v.keys) == 0 {
+				buf.WriteString(" {}\n")
+				break
+			}
+			buf.WriteByte('\n')
+			writeYAML(buf, v, indent+"  ")
+		}
+	}
+}
+
+// Writes comments, ';' comments become '#' ones.
+func writeYAMLComments(buf *bytes.Buffer, indent string, comments []string) {
+	for _, c := range comments {
+		buf.WriteString(indent + "#" + c[1:] + "\n")
+	}
+}
+
+// Writes string plain if it reads back as the same string,
+// otherwise double quoted.
+func yamlScalar(s string) string {
+	if s == "" || !(isAlnum(s[0]) && !('0' <= s[0] && s[0] <= '9') || s[0] == '/' || s[0] == '_') || s[len(s)-1] == ' ' {
+		return jsonQuote(s)
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isAlnum(c) && strings.IndexByte("_./- ", c) < 0 {
+			return jsonQuote(s)
+		}
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null":
+		return jsonQuote(s)
+	}
+	return s
+}
+
+//------------------------------------------------------------
+// Import
+//------------------------------------------------------------
+
+// Converts YAML into document. Returns *ConvertError
+// along with document if anything could not be represented.
+func FromYAML(data []byte) (doc *Document, err error) {
+	if !utf8.Valid(data) {
+		return nil, ErrInvalidUTF8
+	}
+	p, err := newYAMLParser(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	root := newTree()
+	if len(p.lines) > 0 {
+		if p.lines[0].indent != 0 {
+			return nil, p.errorf(p.lines[0], "error, unexpected indentation")
+		}
+		if root, err = p.parseMapping(0); err != nil {
+			return nil, err
+		}
+	}
+	root.trailing = p.comments
+
+	ls := &losses{format: "INI"}
+	return treeToDoc(root, ls), ls.err()
+}
+
+// Line of YAML with comment stripped
+type yamlLine struct {
+	num     int
+	indent  int
+	text    string
+	comment bool
+}
+
+// YAML subset parser
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+
+	// Comments waiting for next key
+	comments []string
+}
+
+// Splits input into not blank lines.
+func newYAMLParser(s string) (p *yamlParser, err error) {
+	p = &yamlParser{}
+	for n, text := range strings.Split(s, "\n") {
+		text = strings.TrimRight(text, " \t\r")
+		body := strings.TrimLeft(text, " ")
+		line := yamlLine{num: n + 1, indent: len(text) - len(body), text: body}
+
+		switch {
+		case body == "":
+			continue
+		case body[0] == '\t':
+			return nil, p.errorf(line, "error, tabs are not allowed in indentation")
+		case body[0] == '#':
+			line.comment = true
+		case body == "---" && len(p.lines) == 0:
+			continue
+		case body == "---" || body == "...":
+			return nil, p.errorf(line, "error, multiple documents are not supported")
+		case body[0] == '%':
+			return nil, p.errorf(line, "error, directives are not supported")
+		default:
+			line.text = stripYAMLComment(body)
+		}
+		p.lines = append(p.lines, line)
+	}
+	return
+}
+
+func (p *yamlParser) errorf(line yamlLine, format string, args ...interface{}) error {
+	return p.errorAt(line, fmt.Errorf(format, args...))
+}
+
+// Positions error at line unless already positioned.
+func (p *yamlParser) errorAt(line yamlLine, err error) error {
+	return errorAt("", &token{line: line.num, col: line.indent + 1}, err)
+}
+
+// Gets next line that is not a comment, collecting comments.
+func (p *yamlParser) peek() (line yamlLine, ok bool) {
+	for ; p.i < len(p.lines); p.i++ {
+		if !p.lines[p.i].comment {
+			return p.lines[p.i], true
+		}
+		p.comments = append(p.comments, p.lines[p.i].text)
+	}
+	return
+}
+
+// Parses block mapping with keys at given indentation.
+func (p *yamlParser) parseMapping(indent int) (t *tree, err error) {
+	t = newTree()
+	for {
+		line, ok := p.peek()
+		if !ok || line.indent < indent {
+			return
+		}
+		if line.indent > indent {
+			return nil, p.errorf(line, "error, unexpected indentation")
+		}
+		if isYAMLItem(line.text) {
+			return nil, p.errorf(line, "error, expected key: %s", line.text)
+		}
+
+		key, rest, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, p.errorf(line, "error, expected key: %s", line.text)
+		}
+		if _, ok := t.values[key]; ok {
+			return nil, p.errorf(line, "error, duplicate key: %s", key)
+		}
+		t.comments[key] = p.comments
+		p.comments = nil
+		p.i++
+
+		var v interface{}
+		if rest != "" {
+			v, err = parseYAMLScalar(rest)
+		} else if next, ok := p.peek(); ok && isYAMLItem(next.text) && next.indent >= indent {
+			v, err = p.parseSequence(next.indent)
+		} else if ok && next.indent > indent {
+			v, err = p.parseMapping(next.indent)
+		}
+		if err != nil {
+			return nil, p.errorAt(line, err)
+		}
+		t.set(key, v, line.num)
+	}
+}
+
+// Parses block sequence of scalars at given indentation.
+func (p *yamlParser) parseSequence(indent int) (v interface{}, err error) {
+	items := []string{}
+	var what lost
+	for {
+		line, ok := p.peek()
+		if !ok || line.indent != indent || !isYAMLItem(line.text) {
+			break
+		}
+		p.i++
+
+		text := strings.TrimLeft(line.text[1:], " ")
+		if _, _, ok := splitYAMLKey(text); ok || text == "" {
+			return nil, p.errorf(line, "error, only scalars are supported in sequences: %s", line.text)
+		}
+		item, err := parseYAMLScalar(text)
+		if err != nil {
+			return nil, p.errorAt(line, err)
+		}
+		switch item := item.(type) {
+		case string:
+			items = append(items, item)
+		case nil:
+			what = "sequence with null"
+		default:
+			what = "sequence of collections"
+		}
+	}
+	if what != "" {
+		return what, nil
+	}
+	return items, nil
+}
+
+// Is line a sequence item ?
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// Splits key: value. Key can be quoted.
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	i := 0
+	if text == "" {
+		return
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		if i = yamlQuoteEnd(text); i < 0 {
+			return
+		}
+	}
+	for ; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			break
+		}
+	}
+	if i == len(text) {
+		return
+	}
+
+	v, err := parseYAMLScalar(strings.TrimSpace(text[:i]))
+	if key, ok = v.(string); !ok || err != nil {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(text[i+1:]), true
+}
+
+// Finds position after closing quote of quoted scalar, -1 if none.
+func yamlQuoteEnd(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && quote == '"':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// Strips comment that follows content, quotes respected.
+func stripYAMLComment(text string) string {
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" :[,-", text[i-1]) >= 0):
+			if end := yamlQuoteEnd(text[i:]); end > 0 {
+				i += end - 1
+			}
+		case c == '#' && i > 0 && (text[i-1] == ' ' || text[i-1] == '\t'):
+			return strings.TrimRight(text[:i], " \t")
+		}
+	}
+	return text
+}
+
+// Parses scalar, flow sequence of scalars or empty
+// flow mapping. Returns string, []string, nil or *tree.
+func parseYAMLScalar(text string) (v interface{}, err error) {
+	switch text {
+	case "":
+		return "", nil
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	case "{}":
+		return newTree(), nil
+	}
+
+	switch text[0] {
+	case '"':
+		if yamlQuoteEnd(text) != len(text) {
+			break
+		}
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("error, invalid quoted scalar: %s", text)
+		}
+		return s, nil
+	case '\'':
+		if yamlQuoteEnd(text) != len(text) {
+			break
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case '[':
+		return parseYAMLFlow(text)
+	case '{', '&', '*', '!', '|', '>', '@', '`', '?':
+		return nil, fmt.Errorf("error, not supported: %s", text)
+	default:
+		return text, nil
+	}
+	return nil, fmt.Errorf("error, unexpected text after quoted scalar: %s", text)
+}
+
+// Parses flow sequence of scalars: [a, "b", 'c']
+func parseYAMLFlow(text string) (v interface{}, err error) {
+	if text[len(text)-1] != ']' {
+		return nil, fmt.Errorf("error, flow sequence must end on the same line: %s", text)
+	}
+	items := []string{}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	for inner != "" {
+		end := strings.IndexByte(inner, ',')
+		if inner[0] == '"' || inner[0] == '\'' {
+			if end = yamlQuoteEnd(inner); end < 0 {
+				return nil, fmt.Errorf("error, unterminated quoted scalar: %s", text)
+			}
+			if next := strings.TrimLeft(inner[end:], " "); next != "" && next[0] != ',' {
+				return nil, fmt.Errorf("error, expected , in: %s", text)
+			}
+			end += strings.IndexByte(inner[end:]+",", ',')
+		}
+		if end < 0 {
+			end = len(inner)
+		}
+
+		item, err := parseYAMLScalar(strings.TrimSpace(inner[:end]))
+		if err != nil {
+			return nil, err
+		}
+		s, ok := item.(string)
+		if !ok {
+			return lost("flow sequence of non scalars"), nil
+		}
+		items = append(items, s)
+
+		if end == len(inner) {
+			break
+		}
+		inner = strings.TrimSpace(inner[end+1:])
+	}
+	return items, nil
+}